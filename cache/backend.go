@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone-plugins/drone-github-actions/pkg/archive"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Backend if no item is stored under a key.
+var ErrNotFound = errors.New("item not found in remote cache")
+
+// Backend stores cached items as tarballs outside of the local cache
+// directory, so they can be shared between runners. Items are stored
+// under the commit they were cloned from and never change once stored.
+type Backend interface {
+	// Get writes the tarball stored under key to w, or returns
+	// ErrNotFound.
+	Get(ctx context.Context, key string, w io.Writer) error
+
+	// Put stores the tarball read from r under key.
+	Put(ctx context.Context, key string, r io.ReadSeeker) error
+}
+
+// BackendOptions configures the credentials of a Backend.
+type BackendOptions struct {
+	Token     string // Bearer token sent to HTTP backends
+	AccessKey string // S3 access key, requests are signed if set
+	SecretKey string // S3 secret key
+	Region    string // S3 region, defaults to us-east-1
+}
+
+// NewBackend returns the backend for the location, which is either a
+// directory, a file:// URL or a http(s):// URL of an HTTP cache server
+// or S3-compatible bucket in path-style, e.g.
+// https://minio.example.com/bucket/prefix.
+func NewBackend(location string, opts BackendOptions) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid remote cache location")
+	}
+	switch u.Scheme {
+	case "":
+		return &fileBackend{root: location}, nil
+	case "file":
+		return &fileBackend{root: u.Path}, nil
+	case "http", "https":
+		return newHTTPBackend(u, opts), nil
+	}
+	return nil, fmt.Errorf("unsupported remote cache location %s", location)
+}
+
+// BlobKey returns the key under which the item cloned from commit of
+// repo is stored in a Backend. Items fetched with other options than a
+// plain git clone, such as with submodules or Git LFS objects, differ
+// in content and are stored under the variant of the options.
+func BlobKey(repo, commit, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf("%s/%s.tar.gz", sha(repo), commit)
+	}
+	return fmt.Sprintf("%s/%s-%s.tar.gz", sha(repo), commit, sha(variant))
+}
+
+// Pack writes the contents of dir as a gzip compressed tarball to w.
+func Pack(w io.Writer, dir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	skip := func(string) bool { return false }
+	if err := writeTree(tw, dir, "", skip); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to pack %s", dir))
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Unpack extracts a tarball written by Pack into dir, rejecting entries
+// and symlinks that would escape it.
+func Unpack(r io.Reader, dir string) error {
+	return archive.ExtractTar(r, &archive.Extractor{Root: dir, DirMode: 0700})
+}
+
+// fileBackend stores items in a directory, e.g. on a shared volume.
+type fileBackend struct {
+	root string
+}
+
+func (b *fileBackend) Get(ctx context.Context, key string, w io.Writer) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (b *fileBackend) Put(ctx context.Context, key string, r io.ReadSeeker) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create directory %s", filepath.Dir(path)))
+	}
+
+	// written next to the item and renamed, so readers never see a
+	// partially written item
+	f, err := os.CreateTemp(filepath.Dir(path), tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (b *fileBackend) path(key string) (string, error) {
+	path := filepath.Join(b.root, filepath.FromSlash(key))
+	if !archive.Within(b.root, path) || strings.HasPrefix(filepath.Base(path), ".") {
+		return "", fmt.Errorf("invalid remote cache key %s", key)
+	}
+	return path, nil
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// unsignedPayload is used as payload hash of signed requests, so
+// uploads do not have to be read twice.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// httpBackend stores items on an HTTP server using GET and PUT, which
+// works with S3-compatible object stores as well as plain HTTP caches.
+type httpBackend struct {
+	base   *url.URL
+	opts   BackendOptions
+	client *http.Client
+	now    func() time.Time
+}
+
+func newHTTPBackend(base *url.URL, opts BackendOptions) *httpBackend {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	return &httpBackend{base: base, opts: opts, client: http.DefaultClient, now: time.Now}
+}
+
+func (b *httpBackend) Get(ctx context.Context, key string, w io.Writer) error {
+	req, err := b.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return err
+	}
+	res, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to get item from remote cache")
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		_, err := io.Copy(w, res.Body)
+		return err
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return fmt.Errorf("failed to get %s from remote cache: %s", key, res.Status)
+}
+
+func (b *httpBackend) Put(ctx context.Context, key string, r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := b.request(ctx, http.MethodPut, key, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	res, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to put item to remote cache")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to put %s to remote cache: %s", key, res.Status)
+	}
+	return nil
+}
+
+// request returns a request for the item at key with credentials.
+func (b *httpBackend) request(ctx context.Context, method, key string, body io.ReadCloser) (*http.Request, error) {
+	u := *b.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+
+	switch {
+	case b.opts.AccessKey != "":
+		req.Header.Set("x-amz-content-sha256", unsignedPayload)
+		sign(req, b.opts, b.now())
+	case b.opts.Token != "":
+		req.Header.Set("Authorization", "Bearer "+b.opts.Token)
+	}
+	return req, nil
+}
+
+// sign adds an AWS signature version 4 authorization header to req,
+// signing the host and all headers already set on req. The payload
+// hash is taken from the x-amz-content-sha256 header.
+func sign(req *http.Request, opts BackendOptions, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", now.Format("20060102T150405Z"))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, opts.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		req.Header.Get("x-amz-date"),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+opts.SecretKey), date)
+	for _, part := range []string{opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-github-actions/pkg/archive"
+	"github.com/pkg/errors"
+)
+
+const (
+	manifestFile    = "manifest.json"
+	manifestVersion = 1
+)
+
+type (
+	// Manifest describes the entries stored in a cache bundle.
+	Manifest struct {
+		Version int           `json:"version"`
+		Created time.Time     `json:"created"`
+		Entries []BundleEntry `json:"entries"`
+	}
+
+	// BundleEntry is a single cache entry stored in a bundle.
+	BundleEntry struct {
+		Key  string `json:"key"`            // Directory name of the entry in the cache
+		Name string `json:"name,omitempty"` // Human readable name, e.g. repo@ref
+	}
+)
+
+// Entries returns the keys of all completed entries in the cache directory.
+func Entries() ([]string, error) {
+	infos, err := os.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cache directory")
+	}
+
+	var keys []string
+	for _, info := range infos {
+		if info.IsDir() && keyName.MatchString(info.Name()) && Exists(filepath.Join(Dir(), info.Name())) {
+			keys = append(keys, info.Name())
+		}
+	}
+	return keys, nil
+}
+
+// Exists returns true if the entry at key was completely added to the cache.
+func Exists(key string) bool {
+	_, err := os.Stat(filepath.Join(key, completionMarkerFile))
+	return err == nil
+}
+
+// Export writes the entries as a gzip compressed tarball to w. Each
+// entry is read while holding its lock shared with other readers, so
+// items being added are not exported. ctx bounds waiting for the locks.
+func Export(ctx context.Context, w io.Writer, entries []BundleEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest := Manifest{Version: manifestVersion, Created: time.Now().UTC(), Entries: entries}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode bundle manifest")
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    manifestFile,
+		Mode:    0600,
+		Size:    int64(len(raw)),
+		ModTime: manifest.Created,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(raw); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := exportEntry(ctx, tw, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// exportEntry adds the current item and metadata of the entry to the
+// tarball.
+func exportEntry(ctx context.Context, tw *tar.Writer, entry BundleEntry) error {
+	dir := filepath.Join(Dir(), entry.Key)
+	if !Exists(dir) {
+		return fmt.Errorf("cache entry %s (%s) is not present in the cache", entry.Key, entry.Name)
+	}
+	lock, err := readLockEntry(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer unlockEntry(dir, lock)
+
+	meta, err := ReadMetadata(dir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to read metadata of %s", dir))
+	}
+	skip := func(rel string) bool {
+		switch {
+		case strings.Contains(rel, "/"):
+			return false
+		case rel == lockFile, rel == dataLinkTmp, rel == metadataFile+".tmp", strings.HasPrefix(rel, tmpPrefix):
+			// temporary items of abandoned changes
+			return true
+		}
+		// previous versions of the item are not exported
+		return strings.HasPrefix(rel, dataLink+".") && rel != meta.Version
+	}
+	if err := writeTree(tw, dir, entry.Key, skip); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to export cache entry %s", entry.Key))
+	}
+	return nil
+}
+
+// Import extracts a bundle created by Export into the cache directory.
+// Entries already present in the cache are left untouched, incomplete
+// ones are replaced while holding their lock. ctx bounds waiting for the
+// locks.
+func Import(ctx context.Context, r io.Reader) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cache bundle")
+	}
+	defer gr.Close()
+
+	root := Dir()
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to create directory %s", root))
+	}
+	staging, err := os.MkdirTemp(root, importPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create staging directory")
+	}
+	defer os.RemoveAll(staging)
+
+	// symlinks may only point inside their own cache entry
+	x := &archive.Extractor{Root: staging, DirMode: 0700, LinkRoot: func(path string) string {
+		rel, _ := filepath.Rel(staging, path)
+		return filepath.Join(staging, strings.SplitN(filepath.ToSlash(rel), "/", 2)[0])
+	}}
+	var manifest *Manifest
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read cache bundle")
+		}
+		if hdr.Name == manifestFile {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, errors.Wrap(err, "failed to decode bundle manifest")
+			}
+			continue
+		}
+		if err := x.Tar(hdr, tr); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.Links(); err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, errors.New("cache bundle does not contain a manifest")
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported cache bundle version %d", manifest.Version)
+	}
+
+	for _, entry := range manifest.Entries {
+		if entry.Key == "" || entry.Key != filepath.Base(entry.Key) || strings.HasPrefix(entry.Key, ".") {
+			return nil, fmt.Errorf("cache bundle contains invalid key %q", entry.Key)
+		}
+		src := filepath.Join(staging, entry.Key)
+		dst := filepath.Join(root, entry.Key)
+		if err := Verify(src); err != nil {
+			return nil, fmt.Errorf("cache bundle entry %s (%s) is invalid: %v", entry.Key, entry.Name, err)
+		}
+		if err := importEntry(ctx, src, dst); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to import cache entry %s", entry.Key))
+		}
+	}
+	return manifest, nil
+}
+
+// importEntry moves the entry extracted to src to dst unless an entry
+// is present there already.
+func importEntry(ctx context.Context, src, dst string) error {
+	lock, err := lockEntry(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer unlockEntry(dst, lock)
+
+	if Exists(dst) {
+		return nil
+	}
+	// discard moves the lock file away with the incomplete entry, so
+	// processes waiting for the lock take the lock of the new entry
+	if err := discard(dst); err != nil {
+		return errors.Wrap(err, "failed to remove incomplete entry")
+	}
+	return os.Rename(src, dst)
+}
+
+// writeTree adds the contents of dir to the tarball under prefix,
+// skipping the paths, relative to dir, for which skip returns true.
+func writeTree(tw *tar.Writer, dir, prefix string, skip func(rel string) bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if skip(filepath.ToSlash(rel)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	key := GetKeyName("https://github.com/actions/checkoutv4")
+	require.NoError(t, Add(context.Background(), key, nil, func(data string) error {
+		if err := os.WriteFile(filepath.Join(data, "action.yml"), []byte("name: checkout"), 0644); err != nil {
+			return err
+		}
+		return os.Symlink("action.yml", filepath.Join(data, "action.yaml"))
+	}))
+
+	keys, err := Entries()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Base(key)}, keys)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, []BundleEntry{{Key: keys[0], Name: "actions/checkout@v4"}}))
+
+	// import into an empty cache
+	t.Setenv("HOME", t.TempDir())
+	manifest, err := Import(context.Background(), &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "actions/checkout@v4", manifest.Entries[0].Name)
+
+	imported := GetKeyName("https://github.com/actions/checkoutv4")
+	assert.True(t, Exists(imported))
+	assert.NoError(t, Verify(imported))
+	content, err := os.ReadFile(filepath.Join(DataDir(imported), "action.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "name: checkout", string(content))
+}
+
+func TestImportRejectsEscapes(t *testing.T) {
+	for name, hdr := range map[string]*tar.Header{
+		"path":             {Name: "abc/../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		"symlink":          {Name: "abc/data/link", Typeflag: tar.TypeSymlink, Linkname: "../../def"},
+		"absolute-symlink": {Name: "abc/data/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gw)
+			raw, _ := json.Marshal(Manifest{Version: manifestVersion, Entries: []BundleEntry{{Key: "abc"}}})
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: manifestFile, Mode: 0600, Size: int64(len(raw))}))
+			_, err := tw.Write(raw)
+			require.NoError(t, err)
+			require.NoError(t, tw.WriteHeader(hdr))
+			require.NoError(t, tw.Close())
+			require.NoError(t, gw.Close())
+
+			_, err = Import(context.Background(), &buf)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExportSkipsTemporaryItems(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	key := GetKeyName("https://github.com/actions/checkoutv4")
+	require.NoError(t, Add(context.Background(), key, nil, func(data string) error {
+		return os.WriteFile(filepath.Join(data, "action.yml"), []byte("name: checkout"), 0644)
+	}))
+	// left behind by a process which exited while replacing the item
+	require.NoError(t, os.MkdirAll(filepath.Join(key, tmpPrefix+dataLink+".1", "partial"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(key, metadataFile+".tmp"), []byte("{"), 0600))
+	require.NoError(t, os.Symlink(dataLink+".1", filepath.Join(key, dataLinkTmp)))
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, []BundleEntry{{Key: filepath.Base(key)}}))
+
+	gr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Contains(t, names, filepath.Base(key)+"/"+metadataFile)
+	for _, name := range names {
+		assert.NotContains(t, name, tmpPrefix)
+		assert.NotContains(t, name, ".tmp")
+		assert.NotContains(t, name, lockFile)
+	}
+}
+
+func TestExportImportLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key := GetKeyName("https://github.com/actions/checkoutv4")
+	require.NoError(t, Add(context.Background(), key, nil, func(data string) error {
+		return os.WriteFile(filepath.Join(data, "action.yml"), []byte("name: checkout"), 0644)
+	}))
+	entries := []BundleEntry{{Key: filepath.Base(key)}}
+
+	// entries being changed are not exported
+	lock, err := lockEntry(context.Background(), key)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Export(ctx, io.Discard, entries), ErrLockTimeout)
+	unlockEntry(key, lock)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, entries))
+	bundle := buf.Bytes()
+
+	// entries being added are not replaced
+	t.Setenv("HOME", t.TempDir())
+	key = GetKeyName("https://github.com/actions/checkoutv4")
+	lock, err = lockEntry(context.Background(), key)
+	require.NoError(t, err)
+	partial := filepath.Join(key, tmpPrefix+dataLink+".1")
+	require.NoError(t, os.MkdirAll(partial, 0700))
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Import(ctx, bytes.NewReader(bundle))
+	assert.ErrorIs(t, err, ErrLockTimeout)
+	assert.DirExists(t, partial)
+	unlockEntry(key, lock)
+
+	// incomplete entries are replaced once they are unlocked
+	_, err = Import(context.Background(), bytes.NewReader(bundle))
+	require.NoError(t, err)
+	assert.True(t, Exists(key))
+	assert.NoDirExists(t, partial)
+}
@@ -1,11 +1,14 @@
 package cache
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rogpeppe/go-internal/lockedfile"
@@ -14,35 +17,164 @@ import (
 
 const (
 	completionMarkerFile = ".done"
+	lockFile             = ".started"
+	dataLink             = "data"
+	dataLinkTmp          = ".data.tmp"
+	tmpPrefix            = ".tmp-"
 )
 
-func Add(key string, addItem func() error) error {
-	if err := os.MkdirAll(key, 0700); err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to create directory %s", key))
+// ErrLockTimeout is returned if the lock of an entry could not be
+// taken before the context was done.
+var ErrLockTimeout = errors.New("timed out waiting for cache entry lock")
+
+// dir is the cache directory, $HOME/.cache if empty.
+var dir string
+
+// SetDir sets the cache directory.
+func SetDir(d string) {
+	dir = d
+}
+
+// DataDir returns the directory holding the item of the entry at key.
+func DataDir(key string) string {
+	return filepath.Join(key, dataLink)
+}
+
+// Add adds the item at key to the cache using addItem unless it is
+// present already. addItem is called with the directory to add the
+// item to and may fill in details of the item in the metadata, which
+// is recorded alongside the entry. Entries whose contents no longer
+// match their recorded digest are added again. ctx bounds waiting for
+// the lock of the entry, which is held while the item is added.
+func Add(ctx context.Context, key string, meta *Metadata, addItem func(dir string) error) error {
+	lock, err := lockEntry(ctx, key)
+	if err != nil {
+		return err
 	}
+	defer unlockEntry(key, lock)
 
-	lockFilepath := filepath.Join(key, ".started")
-	slog.Debug("taking lock", "key", lockFilepath)
-	lock, err := lockedfile.Create(lockFilepath)
-	slog.Debug("took lock", "key", lockFilepath)
+	// If data is already present and unmodified, return
+	if Exists(key) {
+		err := Verify(key)
+		if err == nil {
+			if err := touch(key, false); err != nil {
+				slog.Warn("failed to update cache metadata", "key", key, "error", err)
+			}
+			return nil
+		}
+		slog.Warn("cache entry failed verification, adding it again", "key", key, "error", err)
+		if err := reset(key); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to reset cache entry %s", key))
+		}
+	}
+	return populate(key, meta, addItem)
+}
 
+// Replace replaces the item at key using addItem if stale returns true
+// for the metadata of the entry, or adds it if it is not present. The
+// new item is populated next to the current one and swapped in
+// atomically, so readers see either the previous or the new item. If
+// the entry is not stale it is marked as validated.
+func Replace(ctx context.Context, key string, meta *Metadata, addItem func(dir string) error, stale func(*Metadata) bool) error {
+	lock, err := lockEntry(ctx, key)
 	if err != nil {
-		return errors.Wrap(err, "failed to take file lock")
+		return err
 	}
-	defer func() {
-		if err := lock.Close(); err != nil {
-			slog.Error("failed to release lock", "key", lockFilepath, "error", err)
+	defer unlockEntry(key, lock)
+
+	if Exists(key) && Verify(key) == nil {
+		current, err := ReadMetadata(key)
+		if err == nil && !stale(current) {
+			return touch(key, true)
 		}
-		slog.Debug("released lock", "key", lockFilepath)
-	}()
-	// If data is already present, return
-	if _, err := os.Stat(filepath.Join(key, completionMarkerFile)); err == nil {
-		return nil
 	}
+	return populate(key, meta, addItem)
+}
+
+// Get returns an error if the entry at key is not present in the
+// cache or fails verification, and records its use otherwise.
+func Get(ctx context.Context, key string) error {
+	if !Exists(key) {
+		return fmt.Errorf("cache entry %s is not present", key)
+	}
+	lock, err := lockEntry(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer unlockEntry(key, lock)
+
+	if err := Verify(key); err != nil {
+		return err
+	}
+	return touch(key, false)
+}
 
-	if err := addItem(); err != nil {
+// populate adds the item to a temporary directory of the entry at key,
+// renames it to a new version directory and atomically points the data
+// link at it, so a partially added item is never visible. Previous
+// versions but the last one, which may still be read, are removed. The
+// caller must hold the entry lock.
+func populate(key string, meta *Metadata, addItem func(dir string) error) error {
+	var previous string
+	if current, err := ReadMetadata(key); err == nil {
+		previous = current.Version
+	}
+
+	// remove items left behind by an earlier attempt
+	removeTemp(key)
+
+	version := fmt.Sprintf("%s.%d", dataLink, time.Now().UnixNano())
+	tmpDir := filepath.Join(key, tmpPrefix+version)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create directory %s", tmpDir))
+	}
+	if err := addItem(tmpDir); err != nil {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			slog.Warn("failed to remove partially added item", "path", tmpDir, "error", err)
+		}
 		return errors.Wrap(err, fmt.Sprintf("failed to add item: %s to cache", key))
 	}
+	versionDir := filepath.Join(key, version)
+	if err := os.Rename(tmpDir, versionDir); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to move item to %s", versionDir))
+	}
+
+	size, err := dirSize(versionDir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to compute size of %s", versionDir))
+	}
+	sum, err := digest(versionDir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to compute digest of %s", versionDir))
+	}
+
+	// entries created before items were versioned hold the item
+	// in the data directory itself, which cannot be swapped.
+	if info, err := os.Lstat(DataDir(key)); err == nil && info.IsDir() {
+		if err := os.RemoveAll(DataDir(key)); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(key, dataLinkTmp)
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(version, tmp); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to link %s", versionDir))
+	}
+	if err := os.Rename(tmp, DataDir(key)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to link %s", versionDir))
+	}
+
+	now := time.Now().UTC()
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	meta.Created, meta.LastUsed, meta.Validated = now, now, now
+	meta.Size, meta.Digest, meta.Version = size, sum, version
+	if err := writeMetadata(key, meta); err != nil {
+		return err
+	}
 
 	integrityFpath := filepath.Join(key, completionMarkerFile)
 	f, err := os.Create(integrityFpath)
@@ -51,18 +183,147 @@ func Add(key string, addItem func() error) error {
 	}
 	f.Close()
 
+	removeVersions(key, version, previous)
 	return nil
 }
 
+// removeVersions removes the version directories of the entry at key
+// except the given ones.
+func removeVersions(key string, keep ...string) {
+	infos, err := os.ReadDir(key)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || !strings.HasPrefix(name, dataLink+".") || contains(keep, name) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(key, name)); err != nil {
+			slog.Warn("failed to remove old cache item", "path", filepath.Join(key, name), "error", err)
+		}
+	}
+}
+
+// removeTemp removes the temporary items of the entry at key. Temporary
+// items are only written while holding the entry lock, so any present
+// when the lock is taken were left behind by a process that exited
+// while adding an item. The caller must hold the entry lock.
+func removeTemp(key string) {
+	infos, err := os.ReadDir(key)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if name != dataLinkTmp && !strings.HasPrefix(name, tmpPrefix) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(key, name)); err != nil {
+			slog.Warn("failed to remove temporary cache item", "path", filepath.Join(key, name), "error", err)
+		}
+	}
+}
+
+func contains(slice []string, val string) bool {
+	for _, item := range slice {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
+// lockEntry takes the lock of the entry at key, giving up with
+// ErrLockTimeout once ctx is done. Once taken, the lock file is checked
+// to still be in place since the entry may have been evicted while
+// waiting for the lock.
+func lockEntry(ctx context.Context, key string) (*lockedfile.File, error) {
+	return takeLock(ctx, key, lockedfile.Create)
+}
+
+// readLockEntry takes the lock of the entry at key shared with other
+// readers, so the entry is not changed while it is read.
+func readLockEntry(ctx context.Context, key string) (*lockedfile.File, error) {
+	return takeLock(ctx, key, func(path string) (*lockedfile.File, error) {
+		return lockedfile.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+	})
+}
+
+func takeLock(ctx context.Context, key string, open func(path string) (*lockedfile.File, error)) (*lockedfile.File, error) {
+	lockFilepath := filepath.Join(key, lockFile)
+	for {
+		if err := os.MkdirAll(key, 0700); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to create directory %s", key))
+		}
+
+		slog.Debug("taking lock", "key", lockFilepath)
+		lock, err := acquire(ctx, lockFilepath, open)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("took lock", "key", lockFilepath)
+
+		locked, err := lock.Stat()
+		if err == nil {
+			if current, err := os.Stat(lockFilepath); err == nil && os.SameFile(locked, current) {
+				return lock, nil
+			}
+		}
+		unlockEntry(key, lock)
+	}
+}
+
+// acquire takes the file lock at path, opening it with open. The lock
+// is taken in the background so waiting can be abandoned once ctx is
+// done, in which case the lock is released as soon as it is taken.
+func acquire(ctx context.Context, path string, open func(path string) (*lockedfile.File, error)) (*lockedfile.File, error) {
+	type result struct {
+		lock *lockedfile.File
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		lock, err := open(path)
+		ch <- result{lock, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "failed to take file lock")
+		}
+		return r.lock, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.err == nil {
+				r.lock.Close()
+			}
+		}()
+		return nil, fmt.Errorf("%w %s: %w", ErrLockTimeout, path, ctx.Err())
+	}
+}
+
+func unlockEntry(key string, lock *lockedfile.File) {
+	if err := lock.Close(); err != nil {
+		slog.Error("failed to release lock", "key", key, "error", err)
+	}
+	slog.Debug("released lock", "key", key)
+}
+
 // GetKeyName generate unique file path inside cache directory
 // based on name provided
 func GetKeyName(name string) string {
-	return filepath.Join(getCacheDir(), sha(name))
+	return filepath.Join(Dir(), sha(name))
 }
 
-func getCacheDir() string {
-	dir, _ := os.UserHomeDir()
-	return filepath.Join(dir, ".cache")
+// Dir returns the cache directory.
+func Dir() string {
+	if dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache")
 }
 
 func sha(s string) string {
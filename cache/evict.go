@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// EvictOptions configures which entries Evict removes.
+type EvictOptions struct {
+	TTL     time.Duration // Remove entries not used for longer than TTL
+	MaxSize int64         // Remove least recently used entries until the cache is at most MaxSize bytes
+	Keep    []string      // Keys which are never removed
+}
+
+// Enabled returns true if any eviction policy is configured.
+func (o EvictOptions) Enabled() bool {
+	return o.TTL > 0 || o.MaxSize > 0
+}
+
+// evictLockTimeout bounds waiting for the lock of an entry to evict.
+// Entries locked for longer are in use and skipped.
+const evictLockTimeout = 10 * time.Second
+
+type entry struct {
+	key  string
+	meta *Metadata
+}
+
+// Evict removes entries according to the options and returns the
+// removed keys. Entries are removed while holding their lock, so
+// entries which are being added concurrently are never removed.
+// Entries whose lock cannot be taken within evictLockTimeout are
+// skipped.
+func Evict(opts EvictOptions) ([]string, error) {
+	names, err := Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []entry
+		total   int64
+	)
+	for _, name := range names {
+		key := filepath.Join(Dir(), name)
+		meta, err := ReadMetadata(key)
+		if err != nil {
+			slog.Warn("skipping cache entry with unreadable metadata", "key", key, "error", err)
+			continue
+		}
+		entries = append(entries, entry{key: key, meta: meta})
+		total += meta.Size
+	}
+	// least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].meta.LastUsed.Before(entries[j].meta.LastUsed)
+	})
+
+	var removed []string
+	now := time.Now()
+	for _, e := range entries {
+		expired := opts.TTL > 0 && now.Sub(e.meta.LastUsed) > opts.TTL
+		oversize := opts.MaxSize > 0 && total > opts.MaxSize
+		if !expired && !oversize || keep(opts.Keep, e.key) {
+			continue
+		}
+		ok, err := remove(e.key, e.meta.LastUsed)
+		if errors.Is(err, ErrLockTimeout) {
+			slog.Warn("skipping locked cache entry", "key", e.key)
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, e.key)
+			total -= e.meta.Size
+		}
+	}
+	return removed, nil
+}
+
+// remove deletes the entry at key unless it was used after lastUsed.
+// The entry is renamed while its lock is held and deleted afterwards,
+// so the key never refers to a partially deleted entry.
+func remove(key string, lastUsed time.Time) (bool, error) {
+	// already removed, do not recreate it by locking
+	if !Exists(key) {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), evictLockTimeout)
+	defer cancel()
+	lock, err := lockEntry(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if meta, err := ReadMetadata(key); err != nil || meta.LastUsed.After(lastUsed) {
+		unlockEntry(key, lock)
+		return false, nil
+	}
+
+	err = discard(key)
+	unlockEntry(key, lock)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to evict cache entry %s", key))
+	}
+	return true, nil
+}
+
+// discard renames the entry at key out of the way and deletes it. The
+// caller must hold the entry lock.
+func discard(key string) error {
+	trash := filepath.Join(filepath.Dir(key), fmt.Sprintf("%s%s-%d", evictPrefix, filepath.Base(key), time.Now().UnixNano()))
+	if err := os.Rename(key, trash); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(trash); err != nil {
+		slog.Warn("failed to delete evicted cache entry", "path", trash, "error", err)
+	}
+	return nil
+}
+
+func keep(keys []string, key string) bool {
+	for _, k := range keys {
+		if filepath.Clean(k) == filepath.Clean(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addEntry(t *testing.T, name string, size int, lastUsed time.Time) string {
+	key := GetKeyName(name)
+	require.NoError(t, Add(context.Background(), key, nil, func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "file"), []byte(strings.Repeat("x", size)), 0600)
+	}))
+	meta, err := ReadMetadata(key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), meta.Size)
+	meta.LastUsed = lastUsed
+	require.NoError(t, writeMetadata(key, meta))
+	return key
+}
+
+func TestEvict(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	now := time.Now()
+	oldest := addEntry(t, "oldest", 100, now.Add(-72*time.Hour))
+	old := addEntry(t, "old", 100, now.Add(-48*time.Hour))
+	recent := addEntry(t, "recent", 100, now.Add(-time.Hour))
+	current := addEntry(t, "current", 100, now)
+
+	// ttl, keeping an expired entry
+	removed, err := Evict(EvictOptions{TTL: 24 * time.Hour, Keep: []string{old}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest}, removed)
+
+	// max size removes least recently used first
+	removed, err = Evict(EvictOptions{MaxSize: 150})
+	require.NoError(t, err)
+	assert.Equal(t, []string{old, recent}, removed)
+	assert.True(t, Exists(current))
+	assert.False(t, Exists(recent))
+
+	// a cache hit updates the last used time
+	require.NoError(t, Add(context.Background(), current, nil, func(string) error { t.Fatal("entry added twice"); return nil }))
+	meta, err := ReadMetadata(current)
+	require.NoError(t, err)
+	assert.False(t, meta.LastUsed.Before(now))
+}
+
+func TestEvictWaitsForLock(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	key := addEntry(t, "locked", 10, time.Now().Add(-time.Hour))
+	lock, err := lockEntry(context.Background(), key)
+	require.NoError(t, err)
+
+	done := make(chan []string)
+	go func() {
+		removed, _ := Evict(EvictOptions{TTL: time.Minute})
+		done <- removed
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("entry evicted while locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlockEntry(key, lock)
+	assert.Equal(t, []string{key}, <-done)
+	assert.False(t, Exists(key))
+}
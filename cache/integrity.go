@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// digest returns a hash of the contents of dir. The hash covers the
+// path, type and content of every file below dir, in sorted order.
+func digest(dir string) (string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "link %s %s\n", rel, target)
+		case info.IsDir():
+			fmt.Fprintf(h, "dir %s\n", rel)
+		case info.Mode().IsRegular():
+			sum, err := fileDigest(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "file %s %o %s\n", rel, info.Mode().Perm()&0111, sum)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks that the entry at key is complete and its contents
+// match the digest recorded when it was added.
+func Verify(key string) error {
+	if !Exists(key) {
+		return fmt.Errorf("cache entry %s is incomplete", key)
+	}
+	meta, err := ReadMetadata(key)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to read metadata of %s", key))
+	}
+	if meta.Digest == "" || meta.Version == "" {
+		return fmt.Errorf("cache entry %s has no recorded content digest", key)
+	}
+	if target, err := os.Readlink(DataDir(key)); err != nil || target != meta.Version {
+		return fmt.Errorf("cache entry %s does not point to its recorded version %s", key, meta.Version)
+	}
+	sum, err := digest(filepath.Join(key, meta.Version))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to compute digest of %s", key))
+	}
+	if sum != meta.Digest {
+		return fmt.Errorf("cache entry %s was modified, digest %s does not match %s", key, sum, meta.Digest)
+	}
+	return nil
+}
+
+// reset removes the contents of the entry at key so it can be added
+// again. The caller must hold the entry lock.
+func reset(key string) error {
+	// remove the marker first so the entry is never seen as complete
+	if err := os.Remove(filepath.Join(key, completionMarkerFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	infos, err := os.ReadDir(key)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Name() == lockFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(key, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
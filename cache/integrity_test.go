@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddVerifiesEntries(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	key := GetKeyName("https://github.com/actions/checkoutv4")
+	added := 0
+	addItem := func(dir string) error {
+		added++
+		return os.WriteFile(filepath.Join(dir, "index.js"), []byte("console.log('hello')"), 0644)
+	}
+
+	require.NoError(t, Add(context.Background(), key, nil, addItem))
+	require.NoError(t, Add(context.Background(), key, nil, addItem))
+	assert.Equal(t, 1, added)
+	assert.NoError(t, Get(context.Background(), key))
+
+	// tampered entries fail verification and are added again
+	require.NoError(t, os.WriteFile(filepath.Join(DataDir(key), "index.js"), []byte("steal()"), 0644))
+	assert.Error(t, Get(context.Background(), key))
+	require.NoError(t, Add(context.Background(), key, nil, addItem))
+	assert.Equal(t, 2, added)
+	assert.NoError(t, Verify(key))
+
+	// so are entries with missing or extra files
+	require.NoError(t, os.WriteFile(filepath.Join(DataDir(key), "extra.js"), []byte("steal()"), 0644))
+	assert.Error(t, Verify(key))
+	require.NoError(t, Add(context.Background(), key, nil, addItem))
+	assert.Equal(t, 3, added)
+	assert.NoFileExists(t, filepath.Join(DataDir(key), "extra.js"))
+
+	require.NoError(t, os.Remove(filepath.Join(DataDir(key), "index.js")))
+	assert.Error(t, Verify(key))
+}
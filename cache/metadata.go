@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const metadataFile = "metadata.json"
+
+// Metadata is stored alongside each cache entry.
+type Metadata struct {
+	Repo      string    `json:"repo,omitempty"`   // Repository the entry was cloned from
+	Ref       string    `json:"ref,omitempty"`    // Requested reference
+	Sha       string    `json:"sha,omitempty"`    // Requested commit sha
+	Commit    string    `json:"commit,omitempty"` // Commit checked out in the entry
+	Version   string    `json:"version"`          // Directory of the current item in the entry
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	Validated time.Time `json:"validated"` // Last time the item was checked to be up to date
+	Size      int64     `json:"size"`      // Size of the item in bytes
+	Digest    string    `json:"digest"`    // Digest of the item contents
+}
+
+// ReadMetadata returns the metadata of the entry at key. Entries
+// created before metadata was recorded get metadata derived from
+// the entry itself.
+func ReadMetadata(key string) (*Metadata, error) {
+	raw, err := os.ReadFile(filepath.Join(key, metadataFile))
+	if os.IsNotExist(err) {
+		info, err := os.Stat(filepath.Join(key, completionMarkerFile))
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(key)
+		if err != nil {
+			return nil, err
+		}
+		return &Metadata{Created: info.ModTime().UTC(), LastUsed: info.ModTime().UTC(), Size: size}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to decode metadata of %s", key))
+	}
+	return m, nil
+}
+
+// writeMetadata atomically replaces the metadata of the entry at key.
+func writeMetadata(key string, m *Metadata) error {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cache metadata")
+	}
+	tmp := filepath.Join(key, metadataFile+".tmp")
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to write metadata of %s", key))
+	}
+	return os.Rename(tmp, filepath.Join(key, metadataFile))
+}
+
+// touch updates the last used time, and optionally the validated
+// time, of the entry at key. The caller must hold the entry lock.
+func touch(key string, validated bool) error {
+	m, err := ReadMetadata(key)
+	if err != nil {
+		return err
+	}
+	m.LastUsed = time.Now().UTC()
+	if validated {
+		m.Validated = m.LastUsed
+	}
+	return writeMetadata(key, m)
+}
+
+// dirSize returns the total size of the regular files below dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	key := GetKeyName("https://github.com/actions/checkoutv4")
+	meta := &Metadata{Repo: "https://github.com/actions/checkout", Ref: "v4"}
+	require.NoError(t, Add(context.Background(), key, meta, func(dir string) error {
+		meta.Commit = "8f4b7f84864484a7bf31766abe9204da3cbe65b3"
+		return os.WriteFile(filepath.Join(dir, "action.yml"), []byte("action"), 0600)
+	}))
+
+	got, err := ReadMetadata(key)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/actions/checkout", got.Repo)
+	assert.Equal(t, "v4", got.Ref)
+	assert.Equal(t, "8f4b7f84864484a7bf31766abe9204da3cbe65b3", got.Commit)
+	assert.Equal(t, int64(6), got.Size)
+	assert.WithinDuration(t, time.Now(), got.Created, time.Minute)
+	assert.NoError(t, Verify(key))
+
+	require.NoError(t, os.WriteFile(filepath.Join(DataDir(key), "action.yml"), []byte("changed action"), 0600))
+	assert.Error(t, Verify(key))
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// AbandonedAge is the time after which unfinished changes to the cache
+// are considered abandoned by default.
+const AbandonedAge = time.Hour
+
+// keyName matches the directory names of cache entries, which are sha1
+// hashes of the cached item.
+var keyName = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+const (
+	evictPrefix  = ".evict-"
+	importPrefix = ".import-"
+
+	// recoverLockTimeout bounds waiting for the lock of an entry to
+	// recover. Entries locked for longer are in use and skipped.
+	recoverLockTimeout = 100 * time.Millisecond
+)
+
+// Recover removes what processes which exited while changing the cache
+// left behind: entries that were never completely added, temporary
+// items of entries and leftovers of evictions and imports. Directories
+// which are not cache entries, such as those of other tools sharing the
+// cache directory, are left alone. Only paths
+// not modified for minAge are considered, and entries are only removed
+// while holding their lock, so entries being added are never removed.
+// The paths removed are returned.
+func Recover(minAge time.Duration) ([]string, error) {
+	infos, err := os.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, info := range infos {
+		path := filepath.Join(Dir(), info.Name())
+		if !info.IsDir() || !olderThan(path, minAge) {
+			continue
+		}
+
+		switch name := info.Name(); {
+		case strings.HasPrefix(name, evictPrefix), strings.HasPrefix(name, importPrefix):
+			if err := os.RemoveAll(path); err != nil {
+				slog.Warn("failed to remove abandoned cache directory", "path", path, "error", err)
+				continue
+			}
+			removed = append(removed, path)
+		case !isEntry(path):
+			// directories of other tools sharing the cache directory
+		default:
+			ok, err := recoverEntry(path)
+			if err != nil {
+				slog.Warn("failed to recover cache entry", "key", path, "error", err)
+				continue
+			}
+			if ok {
+				removed = append(removed, path)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// recoverEntry removes the temporary items of the entry at key and the
+// entry itself if it was never completely added, returning true in the
+// latter case. Entries which are locked are skipped.
+func recoverEntry(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoverLockTimeout)
+	defer cancel()
+	lock, err := lockEntry(ctx, key)
+	if errors.Is(err, ErrLockTimeout) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer unlockEntry(key, lock)
+
+	if Exists(key) {
+		removeTemp(key)
+		return false, nil
+	}
+	slog.Info("removing abandoned cache entry", "key", key)
+	return true, discard(key)
+}
+
+// isEntry returns true if the directory at path is a cache entry: its
+// name is a key and it contains the lock, metadata or data of an entry.
+func isEntry(path string) bool {
+	if !keyName.MatchString(filepath.Base(path)) {
+		return false
+	}
+	children, err := os.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, child := range children {
+		switch name := child.Name(); {
+		case name == lockFile, name == metadataFile, name == dataLink, name == dataLinkTmp,
+			strings.HasPrefix(name, dataLink+"."), strings.HasPrefix(name, tmpPrefix+dataLink+"."):
+			return true
+		}
+	}
+	return false
+}
+
+// olderThan returns true if path was last modified longer than d ago.
+func olderThan(path string, d time.Duration) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) >= d
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	complete := addEntry(t, "complete", 10, time.Now())
+	require.NoError(t, os.MkdirAll(filepath.Join(complete, tmpPrefix+"data.1"), 0700))
+
+	abandoned := GetKeyName("abandoned")
+	require.NoError(t, os.MkdirAll(filepath.Join(abandoned, tmpPrefix+"data.1"), 0700))
+	locked := GetKeyName("locked")
+	lock, err := lockEntry(context.Background(), locked)
+	require.NoError(t, err)
+	defer unlockEntry(locked, lock)
+	evicted := filepath.Join(Dir(), evictPrefix+"abc-1")
+	require.NoError(t, os.MkdirAll(evicted, 0700))
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{complete, abandoned, locked, evicted} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+	foreign := filepath.Join(Dir(), "pip")
+	require.NoError(t, os.MkdirAll(filepath.Join(foreign, "http"), 0700))
+	foreignKey := filepath.Join(Dir(), sha("foreign"))
+	require.NoError(t, os.MkdirAll(filepath.Join(foreignKey, "objects"), 0700))
+	for _, path := range []string{foreign, foreignKey} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+	young := GetKeyName("young")
+	require.NoError(t, os.MkdirAll(young, 0700))
+
+	removed, err := Recover(time.Hour)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{abandoned, evicted}, removed)
+	assert.NoDirExists(t, abandoned)
+	assert.NoDirExists(t, evicted)
+	assert.DirExists(t, locked)
+	assert.DirExists(t, young)
+	assert.DirExists(t, filepath.Join(foreign, "http"))
+	assert.DirExists(t, filepath.Join(foreignKey, "objects"))
+	assert.NoDirExists(t, filepath.Join(complete, tmpPrefix+"data.1"))
+	assert.NoError(t, Verify(complete))
+}
+
+func TestAddLockTimeout(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	key := GetKeyName("locked")
+	lock, err := lockEntry(context.Background(), key)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = Add(ctx, key, nil, func(string) error { t.Fatal("item added while locked"); return nil })
+	assert.True(t, errors.Is(err, ErrLockTimeout), err)
+
+	// the abandoned attempt must not keep the lock
+	unlockEntry(key, lock)
+	require.NoError(t, Add(context.Background(), key, nil, func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0600)
+	}))
+	assert.NoError(t, Verify(key))
+}
+
+func TestAddRemovesPartialItems(t *testing.T) {
+	SetDir(t.TempDir())
+	t.Cleanup(func() { SetDir("") })
+
+	key := GetKeyName("partial")
+	failed := errors.New("clone failed")
+	var tmp string
+	err := Add(context.Background(), key, nil, func(dir string) error {
+		tmp = dir
+		return failed
+	})
+	assert.True(t, errors.Is(err, failed), err)
+	assert.False(t, Exists(key))
+	assert.NoDirExists(t, tmp)
+	assert.NoFileExists(t, DataDir(key))
+}
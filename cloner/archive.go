@@ -0,0 +1,227 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/drone-plugins/drone-github-actions/pkg/archive"
+)
+
+// archive formats supported by the archive cloner.
+const (
+	FormatTarball = "tarball"
+	FormatZipball = "zipball"
+)
+
+// maxRedirects is the number of redirects followed when downloading an
+// archive, as by the default http client.
+const maxRedirects = 10
+
+// MethodGit clones repositories using git, archive formats can be used
+// as methods as well.
+const MethodGit = "git"
+
+// NewMethod returns the cloner fetching repositories using the method,
+// git by default.
+func NewMethod(method string, stdout io.Writer, opts Options) (Cloner, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if method == "" || method == MethodGit {
+		return NewWithOptions(1, stdout, opts), nil
+	}
+	if opts.Submodules {
+		return nil, fmt.Errorf("submodules cannot be cloned with clone method %s", method)
+	}
+	c, err := NewArchive(method, stdout)
+	if err != nil {
+		return nil, err
+	}
+	archive := c.(*archiveCloner)
+	archive.lfs = opts.LFS
+	archive.resolver = NewWithOptions(1, stdout, Options{RefPreference: opts.RefPreference}).(Resolver)
+	return archive, nil
+}
+
+// NewArchive returns a cloner which downloads the archive of the
+// resolved commit from the archive endpoint of the repository host,
+// e.g. https://github.com/owner/repo/archive/<sha>.tar.gz, instead of
+// cloning the repository. References are resolved using git.
+func NewArchive(format string, stdout io.Writer) (Cloner, error) {
+	if format != FormatTarball && format != FormatZipball {
+		return nil, fmt.Errorf("unsupported clone method %q", format)
+	}
+	resolver := New(1, stdout).(*cloner)
+	return &archiveCloner{
+		format:   format,
+		resolver: resolver,
+		client:   http.DefaultClient,
+		username: resolver.username,
+		password: resolver.password,
+		stdout:   stdout,
+	}, nil
+}
+
+// archiveCloner downloads and extracts archives of a commit.
+type archiveCloner struct {
+	format   string
+	resolver Resolver
+	client   *http.Client
+	username string
+	password string
+	stdout   io.Writer
+	lfs      bool
+}
+
+// Clone downloads the archive of the commit and extracts it to the
+// target directory. Archives do not contain git metadata.
+func (c *archiveCloner) Clone(ctx context.Context, params Params) error {
+	commit := params.Sha
+	if commit == "" {
+		commit = params.Ref
+	}
+	if !isHash(commit) {
+		var err error
+		if commit, err = c.resolver.Resolve(ctx, params.Repo, params.Ref); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.CreateTemp("", "action-*."+c.ext())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	url := archiveURL(params.Repo, commit, c.ext())
+	fmt.Fprintf(c.stdout, "Downloading %s\n", url)
+	err = retry(func() error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return permanent(c.download(ctx, url, f))
+	})
+	if err != nil {
+		return classify(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if c.format == FormatZipball {
+		err = extractZip(f, params.Dir)
+	} else {
+		err = extractTar(f, params.Dir)
+	}
+	if err != nil || !c.lfs {
+		return err
+	}
+
+	// archives may contain Git LFS pointer files instead of objects
+	lfs := &lfsClient{client: c.client, username: c.username, password: c.password}
+	return classify(retry(func() error {
+		return permanent(lfs.smudge(ctx, params.Dir, params.Repo))
+	}))
+}
+
+// Resolve returns the commit the reference points to.
+func (c *archiveCloner) Resolve(ctx context.Context, repo, ref string) (string, error) {
+	return c.resolver.Resolve(ctx, repo, ref)
+}
+
+// download writes the archive at url to w.
+func (c *archiveCloner) download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" && c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	client := *c.client
+	client.CheckRedirect = c.checkRedirect
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s: %s", ErrAuth, url, res.Status)
+	case res.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s: %s", ErrRefNotFound, url, res.Status)
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("failed to download %s: %s", url, res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// checkRedirect sends the credentials along redirects to the host of
+// the repository or its subdomains, such as from github.com to
+// codeload.github.com, which serves the archives, and drops them for
+// other hosts and from https to http.
+func (c *archiveCloner) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if c.username != "" && c.password != "" && trustedRedirect(via[0].URL, req.URL) {
+		req.SetBasicAuth(c.username, c.password)
+	} else {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// trustedRedirect returns true if credentials sent to from may be sent
+// to the redirect target to.
+func trustedRedirect(from, to *url.URL) bool {
+	if from.Scheme == "https" && to.Scheme != "https" {
+		return false
+	}
+	host, target := strings.ToLower(from.Hostname()), strings.ToLower(to.Hostname())
+	return target == host || strings.HasSuffix(target, "."+host)
+}
+
+func (c *archiveCloner) ext() string {
+	if c.format == FormatZipball {
+		return "zip"
+	}
+	return "tar.gz"
+}
+
+// archiveURL returns the URL of the archive of the commit.
+func archiveURL(repo, commit, ext string) string {
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+	return fmt.Sprintf("%s/archive/%s.%s", repo, commit, ext)
+}
+
+// extractTar extracts a gzip compressed tarball to dir, stripping the
+// top-level directory archives are created with.
+func extractTar(r io.Reader, dir string) error {
+	return archive.ExtractTar(r, &archive.Extractor{Root: dir, Strip: 1})
+}
+
+// extractZip extracts a zip archive to dir, stripping the top-level
+// directory archives are created with.
+func extractZip(f *os.File, dir string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return archive.ExtractZip(f, info.Size(), &archive.Extractor{Root: dir, Strip: 1})
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type archiveEntry struct {
+	name, body, link string
+}
+
+var testCommit = strings.Repeat("c", 40)
+
+func TestArchiveClone(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "repo-" + testCommit + "/"},
+		{name: "repo-" + testCommit + "/action.yml", body: "name: test"},
+		{name: "repo-" + testCommit + "/dist/index.js", body: "main()"},
+		{name: "repo-" + testCommit + "/action.yaml", link: "action.yml"},
+	}
+	archives := map[string][]byte{
+		"/owner/repo/archive/" + testCommit + ".tar.gz": testTarball(t, entries),
+		"/owner/repo/archive/" + testCommit + ".zip":    testZipball(t, entries),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "token" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+	repo := srv.URL + "/owner/repo"
+
+	for _, format := range []string{FormatTarball, FormatZipball} {
+		t.Run(format, func(t *testing.T) {
+			c := testArchiveCloner(format, "secret")
+			dir := testDir(t)
+			require.NoError(t, c.Clone(context.Background(), Params{Repo: repo, Ref: "v1", Dir: dir}))
+
+			content, err := os.ReadFile(filepath.Join(dir, "action.yaml"))
+			require.NoError(t, err)
+			assert.Equal(t, "name: test", string(content))
+			content, err = os.ReadFile(filepath.Join(dir, "dist", "index.js"))
+			require.NoError(t, err)
+			assert.Equal(t, "main()", string(content))
+		})
+	}
+
+	err := testArchiveCloner(FormatTarball, "wrong").Clone(context.Background(), Params{Repo: repo, Ref: "v1", Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrAuth)
+	err = testArchiveCloner(FormatTarball, "secret").Clone(context.Background(), Params{Repo: repo, Ref: strings.Repeat("d", 40), Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrRefNotFound)
+}
+
+func TestArchiveCloneRedirect(t *testing.T) {
+	tarball := testTarball(t, []archiveEntry{{name: "repo-" + testCommit + "/action.yml", body: "name: test"}})
+	leaked := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pass, _ := r.BasicAuth()
+		switch r.Host {
+		case "github.test":
+			// archives are served by another host
+			host := "codeload.github.test"
+			if strings.HasPrefix(r.URL.Path, "/evil/") {
+				host = "evil.test"
+			}
+			http.Redirect(w, r, "http://"+host+"/repo/tar.gz/"+testCommit, http.StatusFound)
+		case "codeload.github.test":
+			if pass != "secret" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(tarball)
+		default:
+			leaked = leaked || pass != ""
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// all hosts are served by the test server
+	c := testArchiveCloner(FormatTarball, "secret")
+	c.client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}}
+
+	// credentials are sent to subdomains of the repository host
+	dir := testDir(t)
+	require.NoError(t, c.Clone(context.Background(), Params{Repo: "http://github.test/owner/repo", Ref: "v1", Dir: dir}))
+	assert.FileExists(t, filepath.Join(dir, "action.yml"))
+
+	// and not to other hosts
+	err := c.Clone(context.Background(), Params{Repo: "http://github.test/evil/repo", Ref: "v1", Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrRefNotFound)
+	assert.False(t, leaked, "credentials sent to another host")
+
+	for target, trusted := range map[string]bool{
+		"https://github.com/owner/repo":               true,
+		"https://codeload.github.com/owner/repo":      true,
+		"http://codeload.github.com/owner/repo":       false,
+		"https://github.com.evil.example/owner/repo":  false,
+		"https://objects.githubusercontent.com/owner": false,
+	} {
+		from, _ := url.Parse("https://github.com/owner/repo/archive/" + testCommit + ".tar.gz")
+		to, _ := url.Parse(target)
+		assert.Equal(t, trusted, trustedRedirect(from, to), target)
+	}
+}
+
+func TestArchiveExtractRejectsEscapes(t *testing.T) {
+	for name, entries := range map[string][]archiveEntry{
+		"path":             {{name: "repo/../../evil", body: "x"}},
+		"symlink":          {{name: "repo/link", link: "../evil"}},
+		"absolute-symlink": {{name: "repo/link", link: "/etc/passwd"}},
+		"symlink-chain":    {{name: "repo/a", link: "."}, {name: "repo/a/b", link: ".."}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := testDir(t)
+			dir := filepath.Join(root, "action")
+
+			err := extractTar(bytes.NewReader(testTarball(t, entries)), dir)
+			assert.Error(t, err)
+
+			f, err := os.CreateTemp(root, "*.zip")
+			require.NoError(t, err)
+			defer f.Close()
+			_, err = f.Write(testZipball(t, entries))
+			require.NoError(t, err)
+			assert.Error(t, extractZip(f, filepath.Join(root, "zip")))
+
+			assert.NoFileExists(t, filepath.Join(root, "evil"))
+			assert.NoFileExists(t, filepath.Join(dir, "b"))
+			assert.NoFileExists(t, filepath.Join(root, "zip", "b"))
+		})
+	}
+}
+
+// testArchiveCloner returns an archive cloner authenticating with the
+// password which resolves all references to testCommit.
+func testArchiveCloner(format, password string) *archiveCloner {
+	return &archiveCloner{
+		format:   format,
+		resolver: staticResolver(testCommit),
+		client:   http.DefaultClient,
+		username: "token",
+		password: password,
+		stdout:   io.Discard,
+	}
+}
+
+type staticResolver string
+
+func (r staticResolver) Resolve(context.Context, string, string) (string, error) {
+	return string(r), nil
+}
+
+func testTarball(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func testZipball(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name}
+		body := e.body
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr.SetMode(os.ModeDir | 0755)
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
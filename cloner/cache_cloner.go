@@ -2,11 +2,15 @@ package cloner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/drone-plugins/drone-github-actions/cache"
+	"github.com/go-git/go-git/v5"
 	"golang.org/x/exp/slog"
 )
 
@@ -14,31 +18,304 @@ func NewCache(cloner Cloner) *cacheCloner {
 	return &cacheCloner{cloner: cloner}
 }
 
+// NewOfflineCache returns a cacheCloner that only serves repositories
+// already present in the cache, as fetched by the cloner, and never
+// accesses the network. The cloner is only used to look up its entries.
+func NewOfflineCache(cloner Cloner) *cacheCloner {
+	return &cacheCloner{cloner: cloner, offline: true}
+}
+
 type cacheCloner struct {
-	cloner Cloner
+	cloner      Cloner
+	offline     bool
+	freshness   time.Duration
+	lockTimeout time.Duration
+	remote      cache.Backend
+
+	mu       sync.Mutex
+	uncached []string // temporary directories of repositories cloned without cache
+}
+
+// WithFreshness sets how long repositories cloned from a branch or tag
+// are used from the cache before checking whether the reference has
+// moved. Zero disables revalidation.
+func (c *cacheCloner) WithFreshness(d time.Duration) *cacheCloner {
+	c.freshness = d
+	return c
+}
+
+// WithLockTimeout sets how long to wait for another process adding the
+// same repository to the cache. Once elapsed the repository is cloned
+// outside of the cache. Zero waits indefinitely.
+func (c *cacheCloner) WithLockTimeout(d time.Duration) *cacheCloner {
+	c.lockTimeout = d
+	return c
+}
+
+// WithRemote sets the backend shared between runners, which is checked
+// for the resolved commit before cloning and to which cloned
+// repositories are uploaded.
+func (c *cacheCloner) WithRemote(b cache.Backend) *cacheCloner {
+	c.remote = b
+	return c
+}
+
+// CacheKey returns the cache key under which the repository is cached
+// when fetched by the cloner. Repositories fetched with other methods or
+// options are cached under different keys.
+func CacheKey(cl Cloner, repo, ref, sha string) string {
+	return cache.GetKeyName(fmt.Sprintf("%s%s%s%s", repo, ref, sha, variantOf(cl)))
 }
 
 // Clone method clones the repository & caches it if not present in cache already.
 func (c *cacheCloner) Clone(ctx context.Context, repo, ref, sha string) (string, error) {
-	key := cache.GetKeyName(fmt.Sprintf("%s%s%s", repo, ref, sha))
-	codedir := filepath.Join(key, "data")
+	key := CacheKey(c.cloner, repo, ref, sha)
+	codedir := cache.DataDir(key)
 
-	cloneFn := func() error {
-		// Remove stale data
-		if err := os.RemoveAll(codedir); err != nil {
-			slog.Error("cannot remove code directory", codedir, err)
+	if c.offline {
+		if !cache.Exists(key) {
+			return "", fmt.Errorf("%s@%s is not present in the action cache and offline mode is enabled", repo, ref)
 		}
-
-		if err := os.MkdirAll(codedir, 0700); err != nil {
-			slog.Error("failed to create code directory", codedir, err)
-			return err
+		lockCtx, cancel := c.lockContext(ctx)
+		defer cancel()
+		if err := cache.Get(lockCtx, key); err != nil {
+			return "", fmt.Errorf("%s@%s cannot be used from the action cache: %w", repo, ref, err)
 		}
-		return c.cloner.Clone(ctx,
-			Params{Repo: repo, Ref: ref, Sha: sha, Dir: codedir})
+		return codedir, nil
 	}
 
-	if err := cache.Add(key, cloneFn); err != nil {
+	meta := &cache.Metadata{Repo: repo, Ref: ref, Sha: sha}
+	lockCtx, cancel := c.lockContext(ctx)
+	defer cancel()
+	err := cache.Add(lockCtx, key, meta, c.cloneFn(ctx, meta))
+	if errors.Is(err, cache.ErrLockTimeout) {
+		slog.Warn("timed out waiting for cached repository, cloning without cache", "repo", repo, "ref", ref)
+		return c.cloneUncached(ctx, repo, ref, sha)
+	}
+	if err != nil {
 		return "", err
 	}
+	c.revalidate(ctx, key, repo, ref, sha)
 	return codedir, nil
 }
+
+// variantOf returns the suffix distinguishing the cache keys of
+// repositories fetched by the cloner from those cloned using git with
+// default options, which are cached under the plain key.
+func variantOf(cl Cloner) string {
+	switch c := cl.(type) {
+	case *cloner:
+		return c.opts.variant()
+	case *archiveCloner:
+		v := "#" + c.format
+		if c.lfs {
+			v += "#lfs"
+		}
+		if r, ok := c.resolver.(*cloner); ok && r.opts.RefPreference == PreferBranch {
+			v += "#branch"
+		}
+		return v
+	}
+	return ""
+}
+
+// revalidate checks whether a branch or tag has moved once the cached
+// repository is older than the freshness interval, and replaces the
+// cached repository if it has. Failures are logged and the cached
+// repository is used.
+func (c *cacheCloner) revalidate(ctx context.Context, key, repo, ref, sha string) {
+	resolver, ok := c.cloner.(Resolver)
+	if c.freshness <= 0 || !ok || sha != "" || ref == "" || isHash(ref) {
+		return
+	}
+	meta, err := cache.ReadMetadata(key)
+	if err != nil || time.Since(meta.Validated) < c.freshness {
+		return
+	}
+
+	commit, err := resolver.Resolve(ctx, repo, ref)
+	if err != nil {
+		slog.Warn("failed to revalidate cached repository, using cached version", "repo", repo, "ref", ref, "error", err)
+		return
+	}
+	stale := func(m *cache.Metadata) bool {
+		return m.Commit != commit
+	}
+	meta = &cache.Metadata{Repo: repo, Ref: ref, Sha: sha}
+	lockCtx, cancel := c.lockContext(ctx)
+	defer cancel()
+	if err := cache.Replace(lockCtx, key, meta, c.cloneFn(ctx, meta), stale); err != nil {
+		slog.Warn("failed to update cached repository, using cached version", "repo", repo, "ref", ref, "error", err)
+	}
+}
+
+// lockContext returns the context bounding waiting for the lock of a
+// cache entry.
+func (c *cacheCloner) lockContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.lockTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.lockTimeout)
+}
+
+// cloneUncached clones the repository into a new temporary directory,
+// which is removed by Cleanup.
+func (c *cacheCloner) cloneUncached(ctx context.Context, repo, ref, sha string) (string, error) {
+	dir, err := os.MkdirTemp("", "action-")
+	if err != nil {
+		return "", err
+	}
+	if err := c.cloner.Clone(ctx, Params{Repo: repo, Ref: ref, Sha: sha, Dir: dir}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	c.mu.Lock()
+	c.uncached = append(c.uncached, dir)
+	c.mu.Unlock()
+	return dir, nil
+}
+
+// Cleanup removes the repositories cloned outside of the cache, which
+// must no longer be used. Cached repositories are kept.
+func (c *cacheCloner) Cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, dir := range c.uncached {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("failed to remove repository cloned without cache", "path", dir, "error", err)
+		}
+	}
+	c.uncached = nil
+}
+
+// cloneFn returns the function adding the repository described by
+// meta to the cache, recording the commit that was checked out. With a
+// remote backend the repository is downloaded from it if present, and
+// uploaded to it once cloned otherwise.
+func (c *cacheCloner) cloneFn(ctx context.Context, meta *cache.Metadata) func(dir string) error {
+	return func(dir string) error {
+		if c.remote != nil && c.download(ctx, meta, dir) {
+			return nil
+		}
+		params := Params{Repo: meta.Repo, Ref: meta.Ref, Sha: meta.Sha, Dir: dir}
+		// archives do not record the commit they were created from,
+		// resolve it upfront so it can be recorded.
+		if _, ok := c.cloner.(*archiveCloner); ok {
+			commit, err := c.commit(ctx, meta)
+			if err != nil {
+				return err
+			}
+			params.Sha = commit
+		}
+		if err := c.cloner.Clone(ctx, params); err != nil {
+			return err
+		}
+		if meta.Commit = headCommit(dir); meta.Commit == "" {
+			meta.Commit = params.Sha
+		}
+		if c.remote != nil && meta.Commit != "" {
+			c.upload(ctx, meta.Repo, meta.Commit, dir)
+		}
+		return nil
+	}
+}
+
+// download unpacks the repository described by meta from the remote
+// backend into dir, returning false if it is not present or cannot be
+// used, in which case dir is left empty.
+func (c *cacheCloner) download(ctx context.Context, meta *cache.Metadata, dir string) bool {
+	commit, err := c.commit(ctx, meta)
+	if err != nil {
+		slog.Warn("failed to resolve commit for remote cache", "repo", meta.Repo, "ref", meta.Ref, "error", err)
+		return false
+	}
+
+	err = func() error {
+		f, err := os.CreateTemp("", "action-*.tar.gz")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if err := c.remote.Get(ctx, cache.BlobKey(meta.Repo, commit, variantOf(c.cloner)), f); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := cache.Unpack(f, dir); err != nil {
+			return err
+		}
+		if head := headCommit(dir); head != "" && head != commit {
+			return fmt.Errorf("remote cache item contains commit %q instead of %s", head, commit)
+		}
+		return nil
+	}()
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			slog.Warn("failed to download repository from remote cache", "repo", meta.Repo, "commit", commit, "error", err)
+		}
+		if err := os.RemoveAll(dir); err == nil {
+			os.MkdirAll(dir, 0700)
+		}
+		return false
+	}
+	slog.Info("downloaded repository from remote cache", "repo", meta.Repo, "commit", commit)
+	meta.Commit = commit
+	return true
+}
+
+// upload stores the repository cloned from commit at dir in the remote
+// backend. Failures are logged.
+func (c *cacheCloner) upload(ctx context.Context, repo, commit, dir string) {
+	err := func() error {
+		f, err := os.CreateTemp("", "action-*.tar.gz")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if err := cache.Pack(f, dir); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return c.remote.Put(ctx, cache.BlobKey(repo, commit, variantOf(c.cloner)), f)
+	}()
+	if err != nil {
+		slog.Warn("failed to upload repository to remote cache", "repo", repo, "commit", commit, "error", err)
+	}
+}
+
+// commit returns the commit the repository described by meta resolves
+// to, which is the key of the repository in the remote backend.
+func (c *cacheCloner) commit(ctx context.Context, meta *cache.Metadata) (string, error) {
+	switch {
+	case isHash(meta.Sha):
+		return meta.Sha, nil
+	case isHash(meta.Ref):
+		return meta.Ref, nil
+	}
+	resolver, ok := c.cloner.(Resolver)
+	if !ok {
+		return "", errors.New("cloner cannot resolve references")
+	}
+	return resolver.Resolve(ctx, meta.Repo, meta.Ref)
+}
+
+// headCommit returns the commit checked out in the repository at dir,
+// or an empty string if it cannot be determined.
+func headCommit(dir string) string {
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		return ""
+	}
+	head, err := r.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
@@ -0,0 +1,199 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/drone-plugins/drone-github-actions/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheCloneRevalidate(t *testing.T) {
+	cache.SetDir(testDir(t))
+	t.Cleanup(func() { cache.SetDir("") })
+
+	f := newTestFixture(t)
+	repo := f.FileURL()
+	ctx := context.Background()
+
+	c := NewCache(NewDefault()).WithFreshness(time.Hour)
+	dir, err := c.Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], headCommit(dir))
+
+	// within the freshness interval the cached version is used
+	next := f.Push(t, "next")
+	dir, err = c.Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], headCommit(dir))
+
+	// once expired, the moved branch is cloned again in place
+	c.WithFreshness(time.Nanosecond)
+	again, err := c.Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	assert.Equal(t, dir, again)
+	assert.Equal(t, next, headCommit(again))
+
+	meta, err := cache.ReadMetadata(CacheKey(NewDefault(), repo, "master", ""))
+	require.NoError(t, err)
+	assert.Equal(t, next, meta.Commit)
+	validated := meta.Validated
+
+	// an unchanged branch is only marked as validated
+	_, err = c.Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	meta, err = cache.ReadMetadata(CacheKey(NewDefault(), repo, "master", ""))
+	require.NoError(t, err)
+	assert.Equal(t, next, meta.Commit)
+	assert.True(t, meta.Validated.After(validated))
+	assert.Equal(t, validated, meta.Created)
+}
+
+func TestCacheCloneHTTP(t *testing.T) {
+	cache.SetDir(testDir(t))
+	t.Cleanup(func() { cache.SetDir("") })
+	withFastRetries(t)
+
+	f := newTestFixture(t)
+	srv, url := testServer(t, f, testServerOptions{Username: "token", Password: "secret"})
+	t.Setenv("GITHUB_TOKEN", "secret")
+	ctx := context.Background()
+
+	c := NewCache(New(1, io.Discard)).WithFreshness(time.Nanosecond)
+	for _, ref := range []string{fixtureTag, fixtureSemverHead, fixtureAnnotated} {
+		dir, err := c.Clone(ctx, url, ref, "")
+		require.NoError(t, err)
+		assert.Equal(t, f.Refs[ref], headCommit(dir), ref)
+	}
+
+	// cached repositories are used while the server is unreachable,
+	// even if revalidation is due
+	srv.Close()
+	dir, err := c.Clone(ctx, url, fixtureTag, "")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs[fixtureTag], headCommit(dir))
+
+	_, err = c.Clone(ctx, url, fixtureBranch, "")
+	assert.ErrorIs(t, err, ErrNetwork)
+}
+
+func TestCacheCloneRemote(t *testing.T) {
+	t.Cleanup(func() { cache.SetDir("") })
+	f := newTestFixture(t)
+	repo := f.FileURL()
+	ctx := context.Background()
+
+	remote, err := cache.NewBackend(testDir(t), cache.BackendOptions{})
+	require.NoError(t, err)
+
+	// the first runner clones the repository and uploads it
+	cache.SetDir(testDir(t))
+	_, err = NewCache(NewDefault()).WithRemote(remote).Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+
+	// a runner with an empty cache downloads it without cloning
+	cache.SetDir(testDir(t))
+	dir, err := NewCache(noClone{NewDefault()}).WithRemote(remote).Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], headCommit(dir))
+	meta, err := cache.ReadMetadata(CacheKey(NewDefault(), repo, "master", ""))
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], meta.Commit)
+
+	// runners cloning with other options do not use the plain clone
+	variant := cache.BlobKey(repo, f.Refs["master"], variantOf(NewWithOptions(1, io.Discard, Options{Submodules: true})))
+	assert.ErrorIs(t, remote.Get(ctx, variant, io.Discard), cache.ErrNotFound)
+	cache.SetDir(testDir(t))
+	_, err = NewCache(NewWithOptions(1, io.Discard, Options{Submodules: true})).WithRemote(remote).Clone(ctx, repo, "master", "")
+	require.NoError(t, err)
+	assert.NoError(t, remote.Get(ctx, variant, io.Discard))
+
+	// a moved branch resolves to a commit that is not stored
+	f.Push(t, "next")
+	cache.SetDir(testDir(t))
+	_, err = NewCache(noClone{NewDefault()}).WithRemote(remote).Clone(ctx, repo, "master", "")
+	assert.Error(t, err)
+}
+
+func TestCacheCloneOffline(t *testing.T) {
+	cache.SetDir(testDir(t))
+	t.Cleanup(func() { cache.SetDir("") })
+
+	f := newTestFixture(t)
+	ctx := context.Background()
+	submodules := Options{Submodules: true}
+
+	// the cache is seeded using a non-default variant
+	seeded, err := NewCache(NewWithOptions(1, io.Discard, submodules)).Clone(ctx, f.FileURL(), fixtureTag, "")
+	require.NoError(t, err)
+	assert.Equal(t, cache.DataDir(CacheKey(NewWithOptions(1, io.Discard, submodules), f.FileURL(), fixtureTag, "")), seeded)
+	assert.NotEqual(t, CacheKey(NewDefault(), f.FileURL(), fixtureTag, ""), CacheKey(NewWithOptions(1, io.Discard, submodules), f.FileURL(), fixtureTag, ""))
+
+	dir, err := NewOfflineCache(NewWithOptions(1, io.Discard, submodules)).Clone(ctx, f.FileURL(), fixtureTag, "")
+	require.NoError(t, err)
+	assert.Equal(t, seeded, dir)
+	assert.Equal(t, f.Refs[fixtureTag], headCommit(dir))
+
+	_, err = NewOfflineCache(NewDefault()).Clone(ctx, f.FileURL(), fixtureTag, "")
+	assert.ErrorContains(t, err, "not present in the action cache")
+	_, err = NewOfflineCache(NewWithOptions(1, io.Discard, submodules)).Clone(ctx, f.FileURL(), fixtureBranch, "")
+	assert.ErrorContains(t, err, "not present in the action cache")
+}
+
+func TestCacheCloneLockTimeout(t *testing.T) {
+	cache.SetDir(testDir(t))
+	t.Cleanup(func() { cache.SetDir("") })
+
+	f := newTestFixture(t)
+	ctx := context.Background()
+
+	// another process holds the entry while adding it
+	locked, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- cache.Add(ctx, CacheKey(NewDefault(), f.FileURL(), "master", ""), nil, func(string) error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+
+	c := NewCache(NewDefault()).WithLockTimeout(10 * time.Millisecond)
+	dir, err := c.Clone(ctx, f.FileURL(), "master", "")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], headCommit(dir))
+	assert.NotContains(t, dir, cache.Dir())
+
+	c.Cleanup()
+	assert.NoDirExists(t, dir)
+	close(release)
+	require.NoError(t, <-done)
+}
+
+// noClone resolves references using the embedded cloner but fails
+// to clone.
+type noClone struct {
+	Cloner
+}
+
+func (noClone) Clone(context.Context, Params) error {
+	return errors.New("clone not expected")
+}
+
+func (c noClone) Resolve(ctx context.Context, repo, ref string) (string, error) {
+	return c.Cloner.(Resolver).Resolve(ctx, repo, ref)
+}
+
+func TestResolve(t *testing.T) {
+	f := newTestFixture(t)
+	commit, err := NewDefault().(Resolver).Resolve(context.Background(), f.FileURL(), "master")
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs["master"], commit)
+
+	_, err = NewDefault().(Resolver).Resolve(context.Background(), f.FileURL(), "missing")
+	assert.ErrorIs(t, err, ErrRefNotFound)
+}
@@ -23,4 +23,10 @@ type (
 		// Clone a repository.
 		Clone(context.Context, Params) error
 	}
+
+	// Resolver resolves references of a remote repository.
+	Resolver interface {
+		// Resolve returns the commit a reference points to.
+		Resolve(ctx context.Context, repo, ref string) (string, error)
+	}
 )
@@ -7,28 +7,74 @@ package cloner
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"regexp"
-	"strings"
+	"path/filepath"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
+const maxRetries = 3
+
+// backoffInterval is the initial interval between retries, shortened
+// in tests.
+var backoffInterval = time.Second * 1
+
+// reference kinds preferred when a name is both a tag and a branch.
 const (
-	maxRetries      = 3
-	backoffInterval = time.Second * 1
+	PreferTag    = "tag"
+	PreferBranch = "branch"
 )
 
+// Options configures optional features of the cloner.
+type Options struct {
+	Submodules    bool   // Clone submodules recursively
+	LFS           bool   // Replace Git LFS pointer files with their objects
+	RefPreference string // Reference kind used if a name is both a tag and a branch, tag by default
+}
+
+func (o Options) validate() error {
+	switch o.RefPreference {
+	case "", PreferTag, PreferBranch:
+		return nil
+	}
+	return fmt.Errorf("invalid reference preference %q, must be %s or %s", o.RefPreference, PreferTag, PreferBranch)
+}
+
+func (o Options) variant() string {
+	var v string
+	if o.Submodules {
+		v += "#submodules"
+	}
+	if o.LFS {
+		v += "#lfs"
+	}
+	if o.RefPreference == PreferBranch {
+		v += "#branch"
+	}
+	return v
+}
+
 // New returns a new cloner.
 func New(depth int, stdout io.Writer) Cloner {
+	return NewWithOptions(depth, stdout, Options{})
+}
+
+// NewWithOptions returns a new cloner with optional features enabled.
+func NewWithOptions(depth int, stdout io.Writer, opts Options) Cloner {
 	c := &cloner{
 		depth:  depth,
 		stdout: stdout,
+		opts:   opts,
 	}
 
 	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
@@ -49,96 +95,275 @@ type cloner struct {
 	username string
 	password string
 	stdout   io.Writer
+	opts     Options
 }
 
 // Clone the repository using the built-in Git client.
 func (c *cloner) Clone(ctx context.Context, params Params) error {
+	if err := c.clone(ctx, params); err != nil {
+		return err
+	}
+	return c.populate(ctx, params.Repo, params.Dir)
+}
+
+// clone the repository and check out the reference or commit.
+func (c *cloner) clone(ctx context.Context, params Params) error {
+	// a commit hash used as the reference cannot be cloned as a
+	// branch or tag, fetch the exact commit instead.
+	if isHash(params.Ref) {
+		return classify(c.cloneHash(ctx, params.Repo, params.Ref, params.Dir))
+	}
+
 	opts := &git.CloneOptions{
 		RemoteName: "origin",
 		Progress:   c.stdout,
 		URL:        params.Repo,
 		Tags:       git.NoTags,
 	}
-	// set the reference name if provided
+	// set the reference name if provided, the remote references
+	// are listed to find out whether it is a branch or tag.
 	if params.Ref != "" {
-		opts.ReferenceName = plumbing.ReferenceName(expandRef(params.Ref))
+		name, _, err := c.resolveRef(ctx, params.Repo, params.Ref)
+		if err != nil {
+			return err
+		}
+		opts.ReferenceName = name
 	}
 	// set depth if cloning the head commit of a branch as
 	// opposed to a specific commit sha
 	if params.Sha == "" {
 		opts.Depth = c.depth
 	}
-	if c.username != "" && c.password != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: c.username,
-			Password: c.password,
-		}
-	}
+	opts.Auth = c.auth()
 	// clone the repository
 	var (
 		r   *git.Repository
 		err error
 	)
 
-	retryStrategy := backoff.NewExponentialBackOff()
-	retryStrategy.InitialInterval = backoffInterval
-	retryStrategy.MaxInterval = backoffInterval * 5     // Maximum delay
-	retryStrategy.MaxElapsedTime = backoffInterval * 60 // Maximum time to retry (1min)
+	err = retry(func() error {
+		r, err = git.PlainCloneContext(ctx, params.Dir, false, opts)
+		return permanent(err)
+	})
 
-	b := backoff.WithMaxRetries(retryStrategy, uint64(maxRetries))
+	// If error not nil, then return it
+	if err != nil {
+		return classify(err)
+	}
 
-	err = backoff.Retry(func() error {
-		r, err = git.PlainClone(params.Dir, false, opts)
-		if err == nil {
-			return nil
+	if params.Sha == "" {
+		return nil
+	}
+
+	// checkout the sha
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	return classify(w.Checkout(&git.CheckoutOptions{
+		Hash: plumbing.NewHash(params.Sha),
+	}))
+}
+
+// populate fetches the submodules and Git LFS objects of the checked
+// out commit of the repository at dir, if enabled.
+func (c *cloner) populate(ctx context.Context, repo, dir string) error {
+	if !c.opts.Submodules && !c.opts.LFS {
+		return nil
+	}
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	if c.opts.Submodules {
+		w, err := r.Worktree()
+		if err != nil {
+			return err
 		}
-		if (errors.Is(plumbing.ErrReferenceNotFound, err) || matchRefNotFoundErr(err)) &&
-			!strings.HasPrefix(params.Ref, "refs/") {
-			originalRefName := opts.ReferenceName
-			// If params.Ref is provided without refs/*, then we are assuming it to either refs/heads/ or refs/tags.
-			// Try clone again with inverse ref.
-			if opts.ReferenceName.IsBranch() {
-				opts.ReferenceName = plumbing.ReferenceName("refs/tags/" + params.Ref)
-			} else if opts.ReferenceName.IsTag() {
-				opts.ReferenceName = plumbing.ReferenceName("refs/heads/" + params.Ref)
-			} else {
-				return err // Return err if the reference name is invalid
-			}
-
-			r, err = git.PlainClone(params.Dir, false, opts)
-			if err == nil {
-				return nil
-			}
-			// Change reference name back to original
-			opts.ReferenceName = originalRefName
+		subs, err := w.Submodules()
+		if err != nil {
+			return err
 		}
+		err = retry(func() error {
+			return permanent(subs.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+				Init:              true,
+				RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+				Auth:              c.auth(),
+			}))
+		})
+		if err != nil {
+			return classify(fmt.Errorf("failed to update submodules: %w", err))
+		}
+	}
+
+	if c.opts.LFS {
+		lfs := c.lfsClient()
+		err := retry(func() error {
+			return permanent(smudgeAll(ctx, lfs, r, dir, repo))
+		})
+		if err != nil {
+			return classify(err)
+		}
+	}
+	return nil
+}
+
+// smudgeAll replaces the Git LFS pointer files of the repository at dir
+// and of its checked out submodules.
+func smudgeAll(ctx context.Context, lfs *lfsClient, r *git.Repository, dir, remote string) error {
+	if err := lfs.smudge(ctx, dir, remote); err != nil {
 		return err
-	}, b)
+	}
 
-	// If error not nil, then return it
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	subs, err := w.Submodules()
 	if err != nil {
 		return err
 	}
+	for _, sub := range subs {
+		sr, err := sub.Repository()
+		if errors.Is(err, git.ErrSubmoduleNotInitialized) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		origin, err := sr.Remote(git.DefaultRemoteName)
+		if err != nil {
+			return err
+		}
+		subDir := filepath.Join(dir, filepath.FromSlash(sub.Config().Path))
+		if err := smudgeAll(ctx, lfs, sr, subDir, origin.Config().URLs[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if params.Sha == "" {
-		return nil
+func (c *cloner) lfsClient() *lfsClient {
+	return &lfsClient{client: http.DefaultClient, username: c.username, password: c.password}
+}
+
+// cloneHash fetches a single commit and checks it out in detached
+// HEAD state. Servers that do not allow fetching unadvertised commits
+// fall back to fetching all branches and tags.
+func (c *cloner) cloneHash(ctx context.Context, repo, hash, dir string) error {
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	if _, err := r.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repo},
+	}); err != nil {
+		return err
+	}
+
+	opts := &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(hash + ":refs/remotes/origin/" + hash)},
+		Depth:      c.depth,
+		Progress:   c.stdout,
+		Tags:       git.NoTags,
+		Auth:       c.auth(),
+	}
+	fetch := func() error {
+		err := r.FetchContext(ctx, opts)
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil
+		}
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			return backoff.Permanent(err)
+		}
+		return permanent(err)
+	}
+
+	err = retry(fetch)
+	if errors.Is(err, git.ErrExactSHA1NotSupported) {
+		opts.RefSpecs = []config.RefSpec{
+			"+refs/heads/*:refs/remotes/origin/*",
+			"+refs/tags/*:refs/tags/*",
+		}
+		opts.Depth = 0
+		err = retry(fetch)
+	}
+	if err != nil {
+		return err
 	}
 
-	// checkout the sha
 	w, err := r.Worktree()
 	if err != nil {
 		return err
 	}
 	return w.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(params.Sha),
+		Hash: plumbing.NewHash(hash),
 	})
 }
 
-func matchRefNotFoundErr(err error) bool {
-	if err == nil {
-		return false
+// Resolve returns the commit the reference points to in the remote
+// repository by listing the remote references, without cloning it.
+func (c *cloner) Resolve(ctx context.Context, repo, ref string) (string, error) {
+	_, commit, err := c.resolveRef(ctx, repo, ref)
+	return commit, err
+}
+
+// resolveRef lists the references of the remote repository once and
+// returns the fully qualified name of the reference and the commit it
+// points to.
+func (c *cloner) resolveRef(ctx context.Context, repo, ref string) (plumbing.ReferenceName, string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repo},
+	})
+
+	var refs []*plumbing.Reference
+	err := retry(func() error {
+		var err error
+		refs, err = remote.ListContext(ctx, &git.ListOptions{
+			Auth:          c.auth(),
+			PeelingOption: git.AppendPeeled,
+		})
+		return permanent(err)
+	})
+	if err != nil {
+		return "", "", classify(err)
+	}
+
+	hashes := map[string]string{}
+	for _, r := range refs {
+		if r.Type() == plumbing.HashReference {
+			hashes[r.Name().String()] = r.Hash().String()
+		}
+	}
+	name, commit, ok := selectRef(hashes, ref, c.opts.RefPreference)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s in %s", ErrRefNotFound, ref, repo)
+	}
+	return name, commit, nil
+}
+
+// auth returns the basic auth credentials, if configured.
+func (c *cloner) auth() transport.AuthMethod {
+	if c.username != "" && c.password != "" {
+		return &githttp.BasicAuth{
+			Username: c.username,
+			Password: c.password,
+		}
 	}
-	pattern := `couldn't find remote ref.*`
-	regex := regexp.MustCompile(pattern)
-	return regex.MatchString(err.Error())
+	return nil
+}
+
+// retry runs fn with exponential backoff until it succeeds, returns
+// a permanent error or the retries are exhausted.
+func retry(fn func() error) error {
+	retryStrategy := backoff.NewExponentialBackOff()
+	retryStrategy.InitialInterval = backoffInterval
+	retryStrategy.MaxInterval = backoffInterval * 5     // Maximum delay
+	retryStrategy.MaxElapsedTime = backoffInterval * 60 // Maximum time to retry (1min)
+
+	return backoff.Retry(fn, backoff.WithMaxRetries(retryStrategy, uint64(maxRetries)))
 }
@@ -6,50 +6,267 @@ package cloner
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestClone(t *testing.T) {
+	f := newTestFixture(t)
+	_, httpURL := testServer(t, f, testServerOptions{})
+
+	for transport, url := range map[string]string{"file": f.FileURL(), "http": httpURL} {
+		for name, ref := range map[string]string{
+			"default-branch":    "",
+			"branch":            fixtureBranch,
+			"branch-qualified":  "refs/heads/" + fixtureBranch,
+			"tag":               fixtureSemver,
+			"tag-qualified":     "refs/tags/" + fixtureSemver,
+			"tag-annotated":     fixtureAnnotated,
+			"tag-fallback":      fixtureTag,
+			"tag-special":       fixtureSpecialTag,
+			"branch-fallback":   fixtureSemverHead,
+			"sha":               f.Refs[fixtureOld],
+			"sha-branch-commit": f.Refs[fixtureBranch],
+		} {
+			t.Run(transport+"/"+name, func(t *testing.T) {
+				want := f.Refs[ref]
+				switch {
+				case ref == "":
+					want = f.Refs["master"]
+				case strings.HasPrefix(ref, "refs/"):
+					want = f.Refs[ref[strings.LastIndex(ref, "/")+1:]]
+				case isHash(ref):
+					want = ref
+				}
+
+				dir := testDir(t)
+				err := New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: ref, Dir: dir})
+				require.NoError(t, err)
+				assert.Equal(t, want, headCommit(dir))
+			})
+		}
+	}
+}
+
+func TestClonePreference(t *testing.T) {
+	f := newTestFixture(t)
+	for prefer, want := range map[string]string{
+		"":           f.Refs["refs/tags/"+fixtureAmbiguous],
+		PreferTag:    f.Refs["refs/tags/"+fixtureAmbiguous],
+		PreferBranch: f.Refs["refs/heads/"+fixtureAmbiguous],
+	} {
+		c := NewWithOptions(1, io.Discard, Options{RefPreference: prefer})
+		dir := testDir(t)
+		require.NoError(t, c.Clone(context.Background(), Params{Repo: f.FileURL(), Ref: fixtureAmbiguous, Dir: dir}))
+		assert.Equal(t, want, headCommit(dir), prefer)
+
+		commit, err := c.(Resolver).Resolve(context.Background(), f.FileURL(), fixtureAmbiguous)
+		require.NoError(t, err)
+		assert.Equal(t, want, commit, prefer)
+	}
+}
+
+func TestCloneSha(t *testing.T) {
+	f := newTestFixture(t)
+	dir := testDir(t)
+	err := New(1, io.Discard).Clone(context.Background(), Params{
+		Repo: f.FileURL(), Ref: "master", Sha: f.Refs[fixtureOld], Dir: dir,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs[fixtureOld], headCommit(dir))
+}
+
+func TestCloneAuth(t *testing.T) {
+	f := newTestFixture(t)
+	_, url := testServer(t, f, testServerOptions{Username: "token", Password: "secret"})
+
+	t.Setenv("GITHUB_TOKEN", "")
+	err := New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: fixtureSemver, Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrAuth)
+
+	t.Setenv("GITHUB_TOKEN", "wrong")
+	err = New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: fixtureSemver, Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrAuth)
+
+	t.Setenv("GITHUB_TOKEN", "secret")
+	dir := testDir(t)
+	err = New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: fixtureSemver, Dir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs[fixtureSemver], headCommit(dir))
+
+	commit, err := New(1, io.Discard).(Resolver).Resolve(context.Background(), url, fixtureAnnotated)
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs[fixtureAnnotated], commit)
+}
+
+func TestCloneRetry(t *testing.T) {
+	withFastRetries(t)
+	f := newTestFixture(t)
+
+	// transient failures are retried
+	_, url := testServer(t, f, testServerOptions{Failures: maxRetries})
+	dir := testDir(t)
+	err := New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: fixtureBranch, Dir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, f.Refs[fixtureBranch], headCommit(dir))
+
+	// until the retries are exhausted
+	_, url = testServer(t, f, testServerOptions{Failures: maxRetries + 1})
+	err = New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: fixtureBranch, Dir: testDir(t)})
+	assert.Error(t, err)
+
+	// errors which retrying cannot resolve are not retried, the
+	// references are listed once
+	var requests atomic.Int32
+	_, url = testServer(t, f, testServerOptions{Requests: &requests})
+	err = New(1, io.Discard).Clone(context.Background(), Params{Repo: url, Ref: "missing", Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrRefNotFound)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func testDir(t *testing.T) string {
+	basedir, err := os.MkdirTemp("", "act-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(basedir) })
+	return basedir
+}
+
+func TestCloneHash(t *testing.T) {
+	for name, allowSha := range map[string]bool{
+		"exact":    true,
+		"fallback": false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newTestFixture(t)
+			testGit(t, f.Path(), "config", "uploadpack.allowReachableSHA1InWant", fmt.Sprint(allowSha))
+			// clone a commit not at the tip of any reference, so it
+			// is not advertised by the server.
+			want := f.Refs[fixtureOld]
+
+			dir := testDir(t)
+			err := NewDefault().Clone(context.Background(), Params{Repo: f.FileURL(), Ref: want, Dir: dir})
+			require.NoError(t, err)
+
+			r, err := git.PlainOpen(dir)
+			require.NoError(t, err)
+			head, err := r.Head()
+			require.NoError(t, err)
+			assert.Equal(t, plumbing.HEAD, head.Name(), "expected detached HEAD")
+			assert.Equal(t, want, head.Hash().String())
+
+			// only the exact fetch is shallow
+			shallow, err := r.Storer.Shallow()
+			require.NoError(t, err)
+			assert.Equal(t, allowSha, len(shallow) != 0)
+		})
+	}
+}
+
+func TestCloneErrors(t *testing.T) {
+	f := newTestFixture(t)
 	for name, tt := range map[string]struct {
 		Err      error
 		URL, Ref string
 	}{
-		"tag": {
-			Err: nil,
-			URL: "https://github.com/actions/checkout",
-			Ref: "v2",
+		"ref-not-found": {
+			Err: ErrRefNotFound,
+			URL: f.FileURL(),
+			Ref: "missing",
 		},
-		"branch": {
-			Err: nil,
-			URL: "https://github.com/anchore/scan-action",
-			Ref: "act-fails",
+		"sha-not-found": {
+			Err: ErrRefNotFound,
+			URL: f.FileURL(),
+			Ref: "8f4b7f84864484a7bf31766abe9204da3cbe65b3",
 		},
-		"tag-special": {
-			Err: nil,
-			URL: "https://github.com/shubham149/drone-s3",
-			Ref: "setup-node-and-dependencies+1.0.9",
+		"repo-not-found": {
+			Err: ErrRepoNotFound,
+			URL: "file://" + filepath.Join(testDir(t), "missing.git"),
+			Ref: "master",
 		},
 	} {
 		t.Run(name, func(t *testing.T) {
-			c := NewDefault()
-			err := c.Clone(context.Background(), Params{Repo: tt.URL, Ref: tt.Ref, Dir: testDir(t)})
-			if tt.Err != nil {
-				assert.Error(t, err)
-				assert.Equal(t, tt.Err, err)
-			} else {
-				assert.Empty(t, err)
+			err := NewDefault().Clone(context.Background(), Params{Repo: tt.URL, Ref: tt.Ref, Dir: testDir(t)})
+			assert.ErrorIs(t, err, tt.Err)
+		})
+	}
+}
+
+func TestCloneHashNotOurRef(t *testing.T) {
+	// servers fetching unadvertised commits refuse missing ones with
+	// "not our ref" instead of not advertising them
+	f := newTestFixture(t)
+	err := NewDefault().Clone(context.Background(), Params{Repo: f.FileURL(), Ref: "8f4b7f84864484a7bf31766abe9204da3cbe65b3", Dir: testDir(t)})
+	assert.ErrorIs(t, err, ErrRefNotFound)
+
+	err = classify(errors.New("error decoding upload-pack response: upload-pack: not our ref 8f4b7f84864484a7bf31766abe9204da3cbe65b3"))
+	assert.ErrorIs(t, err, ErrRefNotFound)
+}
+
+func TestCloneSubmodules(t *testing.T) {
+	content := []byte("binary content")
+	sub := testLFSRepo(t, "sub.bin", content)
+
+	work := testDir(t)
+	testGit(t, work, "init", "-q", "-b", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(work, "action.yml"), []byte("name: test"), 0644))
+	testGit(t, work, "-c", "protocol.file.allow=always", "submodule", "add", "-q", sub, "vendor/sub")
+	testGit(t, work, "add", ".")
+	testGit(t, work, "commit", "-q", "-m", "init")
+	bare := filepath.Join(testDir(t), "repo.git")
+	testGit(t, work, "clone", "-q", "--bare", work, bare)
+
+	for name, tt := range map[string]struct {
+		opts Options
+		want string
+	}{
+		"disabled":   {opts: Options{}, want: ""},
+		"submodules": {opts: Options{Submodules: true}, want: "version https://git-lfs.github.com/spec/v1"},
+		"lfs":        {opts: Options{Submodules: true, LFS: true}, want: string(content)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := testDir(t)
+			c := NewWithOptions(1, io.Discard, tt.opts)
+			require.NoError(t, c.Clone(context.Background(), Params{Repo: "file://" + bare, Ref: "master", Dir: dir}))
+
+			path := filepath.Join(dir, "vendor", "sub", "sub.bin")
+			if tt.want == "" {
+				assert.NoFileExists(t, path)
+				return
 			}
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(data), tt.want), string(data))
 		})
 	}
 }
 
-func testDir(t *testing.T) string {
-	basedir, err := os.MkdirTemp("", "act-test")
-	require.NoError(t, err)
-	t.Cleanup(func() { _ = os.RemoveAll(basedir) })
-	return basedir
+// testLFSRepo creates a local bare repository with a Git LFS pointer
+// file at name whose object, content, is stored in the repository.
+func testLFSRepo(t *testing.T, name string, content []byte) string {
+	sum := sha256sum(content)
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, sum, len(content))
+
+	work := testDir(t)
+	testGit(t, work, "init", "-q", "-b", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(pointer), 0644))
+	testGit(t, work, "add", ".")
+	testGit(t, work, "commit", "-q", "-m", "init")
+
+	bare := filepath.Join(testDir(t), "lfs.git")
+	testGit(t, work, "clone", "-q", "--bare", work, bare)
+	object := filepath.Join(bare, "lfs", "objects", sum[0:2], sum[2:4], sum)
+	require.NoError(t, os.MkdirAll(filepath.Dir(object), 0755))
+	require.NoError(t, os.WriteFile(object, content, 0644))
+	return bare
 }
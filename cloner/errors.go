@@ -0,0 +1,85 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// errors returned by the cloner, wrapped together with the
+// underlying error so both can be matched with errors.Is.
+var (
+	ErrAuth         = errors.New("authentication failed")
+	ErrRepoNotFound = errors.New("repository not found")
+	ErrRefNotFound  = errors.New("reference not found")
+	ErrNetwork      = errors.New("network error")
+)
+
+// classify wraps err with the matching cloner error, if any.
+func classify(err error) error {
+	if kind := kindOf(err); kind != nil && !errors.Is(err, kind) {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return err
+}
+
+func kindOf(err error) error {
+	var (
+		netErr net.Error
+		urlErr *url.Error
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrAuth):
+		return ErrAuth
+	case errors.Is(err, ErrRepoNotFound):
+		return ErrRepoNotFound
+	case errors.Is(err, ErrRefNotFound):
+		return ErrRefNotFound
+	case errors.Is(err, ErrNetwork):
+		return ErrNetwork
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return ErrAuth
+	case errors.Is(err, transport.ErrRepositoryNotFound),
+		errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return ErrRepoNotFound
+	case errors.Is(err, plumbing.ErrReferenceNotFound),
+		errors.Is(err, plumbing.ErrObjectNotFound),
+		errors.Is(err, git.NoMatchingRefSpecError{}),
+		notOurRef(err):
+		return ErrRefNotFound
+	case errors.As(err, &netErr), errors.As(err, &urlErr):
+		return ErrNetwork
+	}
+	return nil
+}
+
+// notOurRef returns true if the server refused to send a commit which is
+// not reachable from its references. go-git does not wrap the errors of
+// the upload-pack response, so only the message identifies it.
+func notOurRef(err error) bool {
+	return strings.Contains(err.Error(), "not our ref")
+}
+
+// permanent marks errors that cannot be resolved by retrying.
+func permanent(err error) error {
+	switch kindOf(err) {
+	case ErrAuth, ErrRepoNotFound, ErrRefNotFound:
+		return backoff.Permanent(err)
+	}
+	return err
+}
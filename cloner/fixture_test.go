@@ -0,0 +1,170 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testFixture is a local bare repository with branches and tags of
+// different kinds, which can be served over file:// and smart HTTP.
+type testFixture struct {
+	Dir  string            // directory containing the bare repository
+	Name string            // name of the bare repository in Dir
+	Refs map[string]string // commit each test reference points to
+}
+
+// fixture references, each pointing to a different commit.
+const (
+	fixtureOld        = "old"                               // commit not at the tip of any reference
+	fixtureBranch     = "feature"                           // branch
+	fixtureSemver     = "v1"                                // lightweight semver tag
+	fixtureAnnotated  = "v2.1.0"                            // annotated semver tag
+	fixtureTag        = "release"                           // tag not looking like semver
+	fixtureSpecialTag = "setup-node-and-dependencies+1.0.9" // tag with build metadata
+	fixtureSemverHead = "v9"                                // branch looking like a semver tag
+	fixtureAmbiguous  = "stable"                            // both a branch and a tag
+)
+
+// newTestFixture creates the fixture repository. Commits are made in
+// a linear history on master, references are created along the way.
+func newTestFixture(t *testing.T) *testFixture {
+	work := testDir(t)
+	testGit(t, work, "init", "-q", "-b", "master")
+
+	f := &testFixture{Dir: testDir(t), Name: "repo.git", Refs: map[string]string{}}
+	commit := func(name string) string {
+		require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(name), 0644))
+		testGit(t, work, "add", name)
+		testGit(t, work, "commit", "-q", "-m", name)
+		return testGitOutput(t, work, "rev-parse", "HEAD")
+	}
+
+	f.Refs[fixtureOld] = commit("old")
+	for _, name := range []string{fixtureBranch, fixtureSemverHead} {
+		f.Refs[name] = commit(name)
+		testGit(t, work, "branch", name)
+	}
+	for _, name := range []string{fixtureSemver, fixtureTag, fixtureSpecialTag} {
+		f.Refs[name] = commit(name)
+		testGit(t, work, "tag", name)
+	}
+	f.Refs["refs/heads/"+fixtureAmbiguous] = commit("stable-branch")
+	testGit(t, work, "branch", fixtureAmbiguous)
+	f.Refs["refs/tags/"+fixtureAmbiguous] = commit("stable-tag")
+	testGit(t, work, "tag", fixtureAmbiguous)
+	f.Refs[fixtureAnnotated] = commit(fixtureAnnotated)
+	testGit(t, work, "tag", "-a", "-m", fixtureAnnotated, fixtureAnnotated)
+	f.Refs["master"] = commit("master")
+
+	testGit(t, work, "clone", "-q", "--bare", work, f.Path())
+	testGit(t, f.Path(), "config", "uploadpack.allowReachableSHA1InWant", "true")
+	testGit(t, f.Path(), "config", "http.receivepack", "false")
+	return f
+}
+
+// Path returns the path of the bare repository.
+func (f *testFixture) Path() string {
+	return filepath.Join(f.Dir, f.Name)
+}
+
+// FileURL returns the file:// URL of the repository.
+func (f *testFixture) FileURL() string {
+	return "file://" + f.Path()
+}
+
+// Push pushes a new commit adding the file to master and returns its
+// hash.
+func (f *testFixture) Push(t *testing.T, name string) string {
+	work := testDir(t)
+	testGit(t, work, "clone", "-q", f.Path(), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(name), 0644))
+	testGit(t, work, "add", name)
+	testGit(t, work, "commit", "-q", "-m", name)
+	testGit(t, work, "push", "-q", "origin", "HEAD:master")
+	return testGitOutput(t, work, "rev-parse", "HEAD")
+}
+
+// testServerOptions configures the behaviour of the test server.
+type testServerOptions struct {
+	Username, Password string        // credentials required if set
+	Failures           int32         // number of requests answered with 503 first
+	Requests           *atomic.Int32 // counts the requests, if set
+}
+
+// testServer serves the fixture over smart HTTP using git http-backend
+// and returns the URL of the repository.
+func testServer(t *testing.T, f *testFixture, opts testServerOptions) (*httptest.Server, string) {
+	backend := filepath.Join(testGitOutput(t, f.Dir, "--exec-path"), "git-http-backend")
+	git := &cgi.Handler{
+		Path: backend,
+		Env:  []string{"GIT_PROJECT_ROOT=" + f.Dir, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+
+	var failures atomic.Int32
+	failures.Store(opts.Failures)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Requests != nil {
+			opts.Requests.Add(1)
+		}
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if opts.Username != "" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != opts.Username || pass != opts.Password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		git.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, srv.URL + "/" + f.Name
+}
+
+// withFastRetries shortens the interval between retries for the test.
+func withFastRetries(t *testing.T) {
+	prev := backoffInterval
+	backoffInterval = 10 * time.Millisecond
+	t.Cleanup(func() { backoffInterval = prev })
+}
+
+// testGit runs git in dir, skipping the test if git is not installed.
+func testGit(t *testing.T, dir string, args ...string) {
+	testGitOutput(t, dir, args...)
+}
+
+// testGitOutput runs git in dir and returns its trimmed output.
+func testGitOutput(t *testing.T, dir string, args ...string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		require.NoError(t, err, string(exitErr.Stderr))
+	}
+	require.NoError(t, err)
+	return strings.TrimSpace(string(out))
+}
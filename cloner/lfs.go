@@ -0,0 +1,325 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	cryptosha256 "crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMediaType      = "application/vnd.git-lfs+json"
+
+	// pointer files are small, larger files are never checked.
+	lfsMaxPointerSize = 1024
+)
+
+var lfsOid = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// lfsPointer is a Git LFS pointer file checked out in place of the
+// object it points to.
+type lfsPointer struct {
+	path string
+	oid  string
+	size int64
+}
+
+// lfsClient replaces Git LFS pointer files with the objects they point
+// to, reading objects from the LFS storage of local repositories or
+// downloading them using the Git LFS batch API.
+type lfsClient struct {
+	client   *http.Client
+	username string
+	password string
+}
+
+// smudge replaces the pointer files in the worktree at dir, skipping
+// nested repositories, with the objects from the LFS storage of remote.
+func (c *lfsClient) smudge(ctx context.Context, dir, remote string) error {
+	pointers, err := findPointers(dir)
+	if err != nil || len(pointers) == 0 {
+		return err
+	}
+
+	if local, ok := localPath(remote); ok {
+		for _, p := range pointers {
+			if err := c.replace(p, func(w io.Writer) error {
+				return copyLocalObject(local, p.oid, w)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	actions, err := c.batch(ctx, remote, pointers)
+	if err != nil {
+		return err
+	}
+	for _, p := range pointers {
+		action, ok := actions[p.oid]
+		if !ok {
+			return fmt.Errorf("git lfs object %s of %s is not available", p.oid, p.path)
+		}
+		if err := c.replace(p, func(w io.Writer) error {
+			return c.download(ctx, action, w)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace writes the object of the pointer next to the pointer file,
+// verifies it and renames it over the pointer file.
+func (c *lfsClient) replace(p lfsPointer, fetch func(io.Writer) error) error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p.path), ".lfs-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := cryptosha256.New()
+	if err := fetch(io.MultiWriter(f, h)); err != nil {
+		return fmt.Errorf("failed to fetch git lfs object of %s: %w", p.path, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != p.oid {
+		return fmt.Errorf("git lfs object of %s has digest %s instead of %s", p.path, sum, p.oid)
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p.path)
+}
+
+type (
+	lfsBatchRequest struct {
+		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
+		Objects   []lfsObject `json:"objects"`
+	}
+
+	lfsBatchResponse struct {
+		Objects []lfsObject `json:"objects"`
+	}
+
+	lfsObject struct {
+		Oid     string                `json:"oid"`
+		Size    int64                 `json:"size"`
+		Actions map[string]*lfsAction `json:"actions,omitempty"`
+		Error   *lfsError             `json:"error,omitempty"`
+	}
+
+	lfsAction struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header,omitempty"`
+	}
+
+	lfsError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// batch requests the download actions of the objects of the pointers
+// from the Git LFS server of remote, keyed by object id.
+func (c *lfsClient) batch(ctx context.Context, remote string, pointers []lfsPointer) (map[string]*lfsAction, error) {
+	body := lfsBatchRequest{Operation: "download", Transfers: []string{"basic"}}
+	seen := map[string]bool{}
+	for _, p := range pointers {
+		if !seen[p.oid] {
+			seen[p.oid] = true
+			body.Objects = append(body.Objects, lfsObject{Oid: p.oid, Size: p.size})
+		}
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := lfsEndpoint(remote) + "/objects/batch"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	if c.username != "" && c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %s: %s", ErrAuth, endpoint, res.Status)
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("git lfs batch request to %s failed: %s", endpoint, res.Status)
+	}
+
+	var out lfsBatchResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode git lfs batch response: %w", err)
+	}
+	actions := map[string]*lfsAction{}
+	for _, o := range out.Objects {
+		if o.Error != nil {
+			return nil, fmt.Errorf("git lfs object %s: %s", o.Oid, o.Error.Message)
+		}
+		if a := o.Actions["download"]; a != nil {
+			actions[o.Oid] = a
+		}
+	}
+	return actions, nil
+}
+
+// download writes the object of the download action to w.
+func (c *lfsClient) download(ctx context.Context, action *lfsAction, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", action.Href, res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// findPointers returns the pointer files in the worktree at dir,
+// skipping nested repositories.
+func findPointers(dir string) ([]lfsPointer, error) {
+	var pointers []lfsPointer
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > lfsMaxPointerSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if oid, size, ok := parsePointer(data); ok {
+			pointers = append(pointers, lfsPointer{path: path, oid: oid, size: size})
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// parsePointer returns the object id and size of a Git LFS pointer.
+func parsePointer(data []byte) (string, int64, bool) {
+	if !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return "", 0, false
+	}
+	var (
+		oid  string
+		size int64 = -1
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		key, value, _ := strings.Cut(s.Text(), " ")
+		switch key {
+		case "oid":
+			oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", 0, false
+			}
+			size = n
+		}
+	}
+	if !lfsOid.MatchString(oid) || size < 0 {
+		return "", 0, false
+	}
+	return oid, size, true
+}
+
+// lfsEndpoint returns the Git LFS endpoint of the remote repository.
+func lfsEndpoint(remote string) string {
+	remote = strings.TrimSuffix(remote, "/")
+	if !strings.HasSuffix(remote, ".git") {
+		remote += ".git"
+	}
+	return remote + "/info/lfs"
+}
+
+// localPath returns the path of a remote repository on the local
+// file system.
+func localPath(remote string) (string, bool) {
+	if strings.HasPrefix(remote, "file://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", false
+		}
+		return u.Path, true
+	}
+	return remote, filepath.IsAbs(remote)
+}
+
+// copyLocalObject writes the object from the LFS storage of the local
+// repository, bare or not, to w.
+func copyLocalObject(repo, oid string, w io.Writer) error {
+	rel := filepath.Join("lfs", "objects", oid[0:2], oid[2:4], oid)
+	for _, path := range []string{filepath.Join(repo, rel), filepath.Join(repo, ".git", rel)} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+	return fmt.Errorf("git lfs object %s not found in %s", oid, repo)
+}
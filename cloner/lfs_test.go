@@ -0,0 +1,117 @@
+// Copyright 2022 Harness Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cloner
+
+import (
+	"context"
+	cryptosha256 "crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePointer(t *testing.T) {
+	sum := sha256sum([]byte("x"))
+	oid, size, ok := parsePointer([]byte(fmt.Sprintf("%s\noid sha256:%s\nsize 1\n", lfsPointerVersion, sum)))
+	assert.True(t, ok)
+	assert.Equal(t, sum, oid)
+	assert.Equal(t, int64(1), size)
+
+	for _, data := range []string{
+		"plain file",
+		lfsPointerVersion + "\noid sha256:abc\nsize 1\n",
+		lfsPointerVersion + "\noid sha256:" + sum + "\n",
+	} {
+		_, _, ok := parsePointer([]byte(data))
+		assert.False(t, ok, data)
+	}
+}
+
+func TestSmudgeBatch(t *testing.T) {
+	content := []byte("binary content")
+	sum := sha256sum(content)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/owner/repo.git/info/lfs/objects/batch":
+			if user, pass, _ := r.BasicAuth(); user != "token" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req lfsBatchRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			var res lfsBatchResponse
+			for _, o := range req.Objects {
+				o.Actions = map[string]*lfsAction{"download": {
+					Href:   srv.URL + "/objects/" + o.Oid,
+					Header: map[string]string{"X-Download": "ok"},
+				}}
+				res.Objects = append(res.Objects, o)
+			}
+			w.Header().Set("Content-Type", lfsMediaType)
+			json.NewEncoder(w).Encode(res)
+		case "/objects/" + sum:
+			if r.Header.Get("X-Download") != "ok" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := testDir(t)
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, sum, len(content))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), []byte(pointer), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.bin"), []byte(pointer), 0644))
+
+	err := (&lfsClient{client: http.DefaultClient}).smudge(context.Background(), dir, srv.URL+"/owner/repo")
+	assert.ErrorIs(t, err, ErrAuth)
+
+	c := &lfsClient{client: http.DefaultClient, username: "token", password: "secret"}
+	require.NoError(t, c.smudge(context.Background(), dir, srv.URL+"/owner/repo"))
+	for _, name := range []string{"a.bin", "b.bin"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func TestSmudgeVerifiesDigest(t *testing.T) {
+	repo := testDir(t)
+	sum := sha256sum([]byte("expected"))
+	object := filepath.Join(repo, "lfs", "objects", sum[0:2], sum[2:4], sum)
+	require.NoError(t, os.MkdirAll(filepath.Dir(object), 0755))
+	require.NoError(t, os.WriteFile(object, []byte("tampered"), 0644))
+
+	dir := testDir(t)
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize 8\n", lfsPointerVersion, sum)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), []byte(pointer), 0644))
+
+	err := (&lfsClient{client: http.DefaultClient}).smudge(context.Background(), dir, "file://"+repo)
+	assert.Error(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "a.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, pointer, string(data))
+}
+
+func sha256sum(data []byte) string {
+	h := cryptosha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
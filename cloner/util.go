@@ -7,6 +7,8 @@ package cloner
 import (
 	"regexp"
 	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 // regular expressions to test whether or not a string is
@@ -14,7 +16,6 @@ import (
 var (
 	sha1   = regexp.MustCompile("^([a-f0-9]{40})$")
 	sha256 = regexp.MustCompile("^([a-f0-9]{64})$")
-	semver = regexp.MustCompile(`^v?((([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?(?:-([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?)(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?)$`)
 )
 
 // helper function returns true if the string is a commit hash.
@@ -22,14 +23,27 @@ func isHash(s string) bool {
 	return sha1.MatchString(s) || sha256.MatchString(s)
 }
 
-// helper function returns the branch name expanded to the
-// fully qualified reference path (e.g refs/heads/master).
-func expandRef(name string) string {
-	if strings.HasPrefix(name, "refs/") {
-		return name
+// selectRef returns the reference the name refers to among the listed
+// references and the commit it points to. Fully qualified names must
+// match exactly, other names are looked up as tag and branch and, if
+// both exist, the preferred kind is chosen, tag by default.
+func selectRef(hashes map[string]string, name, prefer string) (plumbing.ReferenceName, string, bool) {
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "refs/") {
+		tag, branch := "refs/tags/"+name, "refs/heads/"+name
+		candidates = []string{tag, branch}
+		if prefer == PreferBranch {
+			candidates = []string{branch, tag}
+		}
 	}
-	if semver.MatchString(name) {
-		return "refs/tags/" + name
+	for _, candidate := range candidates {
+		// prefer the commit an annotated tag points to
+		if hash, ok := hashes[candidate+"^{}"]; ok {
+			return plumbing.ReferenceName(candidate), hash, true
+		}
+		if hash, ok := hashes[candidate]; ok {
+			return plumbing.ReferenceName(candidate), hash, true
+		}
 	}
-	return "refs/heads/" + name
+	return "", "", false
 }
@@ -6,31 +6,43 @@ package cloner
 
 import "testing"
 
-func TestExpandRef(t *testing.T) {
+func TestSelectRef(t *testing.T) {
+	hashes := map[string]string{
+		"refs/heads/master":   "a",
+		"refs/heads/v1":       "b",
+		"refs/tags/v1":        "c",
+		"refs/tags/v2":        "d",
+		"refs/tags/v2^{}":     "e",
+		"refs/heads/release":  "f",
+		"refs/tags/1.0.0+abc": "g",
+	}
 	tests := []struct {
-		name, prefix, after string
+		name, prefer, ref, hash string
 	}{
 		// branch references
-		{
-			after:  "refs/heads/master",
-			name:   "master",
-			prefix: "refs/heads",
-		},
-		{
-			after:  "refs/heads/master",
-			name:   "master",
-			prefix: "refs/heads/",
-		},
+		{name: "master", ref: "refs/heads/master", hash: "a"},
+		{name: "release", ref: "refs/heads/release", hash: "f"},
+		// tag references, annotated tags are peeled
+		{name: "v2", ref: "refs/tags/v2", hash: "e"},
+		{name: "1.0.0+abc", ref: "refs/tags/1.0.0+abc", hash: "g"},
+		// both a tag and a branch
+		{name: "v1", ref: "refs/tags/v1", hash: "c"},
+		{name: "v1", prefer: "tag", ref: "refs/tags/v1", hash: "c"},
+		{name: "v1", prefer: "branch", ref: "refs/heads/v1", hash: "b"},
 		// is already a ref
-		{
-			after:  "refs/tags/v1.0.0",
-			name:   "refs/tags/v1.0.0",
-			prefix: "refs/heads/",
-		},
+		{name: "refs/heads/v1", prefer: "tag", ref: "refs/heads/v1", hash: "b"},
+		{name: "refs/tags/v1", prefer: "branch", ref: "refs/tags/v1", hash: "c"},
 	}
 	for _, test := range tests {
-		if got, want := expandRef(test.name), test.after; got != want {
-			t.Errorf("Got reference %s, want %s", got, want)
+		ref, hash, ok := selectRef(hashes, test.name, test.prefer)
+		if !ok || ref.String() != test.ref || hash != test.hash {
+			t.Errorf("Got reference %s (%s) for %s preferring %q, want %s (%s)", ref, hash, test.name, test.prefer, test.ref, test.hash)
+		}
+	}
+
+	for _, name := range []string{"missing", "refs/heads/v2", "heads/master"} {
+		if ref, _, ok := selectRef(hashes, name, ""); ok {
+			t.Errorf("Got reference %s for %s, want none", ref, name)
 		}
 	}
 }
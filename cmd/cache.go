@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-plugins/drone-github-actions/cache"
+	"github.com/drone-plugins/drone-github-actions/cloner"
+	"github.com/drone-plugins/drone-github-actions/utils"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var cacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manage the local action cache",
+	Subcommands: []cli.Command{
+		{
+			Name:   "ls",
+			Usage:  "list cached actions",
+			Action: cacheList,
+		},
+		{
+			Name:      "inspect",
+			Usage:     "show details of a cached action",
+			ArgsUsage: "<uses>",
+			Action:    cacheInspect,
+		},
+		{
+			Name:   "verify",
+			Usage:  "check the integrity of cached actions",
+			Action: cacheVerify,
+		},
+		{
+			Name:  "prune",
+			Usage: "remove cached actions",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "remove cached actions not used for longer than the duration",
+				},
+				cli.StringFlag{
+					Name:  "max-size",
+					Usage: "remove least recently used cached actions until the cache is smaller than the size, e.g. 2GB",
+				},
+			},
+			Action: cachePrune,
+		},
+		{
+			Name:      "export",
+			Usage:     "export cached actions to a bundle, all cached actions are exported if none are given",
+			ArgsUsage: "[uses...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "path of the bundle to write",
+					Value: "actions-cache.tar.gz",
+				},
+			},
+			Action: cacheExport,
+		},
+		{
+			Name:      "import",
+			Usage:     "import cached actions from a bundle",
+			ArgsUsage: "<bundle>",
+			Action:    cacheImport,
+		},
+	},
+}
+
+func cacheList(c *cli.Context) error {
+	keys, err := cache.Entries()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tREPO\tREF\tCOMMIT\tSIZE\tLAST USED")
+	for _, key := range keys {
+		meta, err := cache.ReadMetadata(filepath.Join(cache.Dir(), key))
+		if err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%v\n", key, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key, orDash(meta.Repo), orDash(meta.Ref),
+			orDash(meta.Commit), formatSize(meta.Size), meta.LastUsed.Local().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func cacheInspect(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("uses reference of the action to inspect must be set")
+	}
+	repo, ref, err := utils.ParseReference(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	key, err := cacheKey(c, repo, ref)
+	if err != nil {
+		return err
+	}
+	if !cache.Exists(key) {
+		return fmt.Errorf("%s is not present in the action cache", c.Args().First())
+	}
+	meta, err := cache.ReadMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	status := "ok"
+	if err := cache.Verify(key); err != nil {
+		status = err.Error()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Key:\t%s\n", filepath.Base(key))
+	fmt.Fprintf(w, "Path:\t%s\n", key)
+	fmt.Fprintf(w, "Repo:\t%s\n", orDash(meta.Repo))
+	fmt.Fprintf(w, "Ref:\t%s\n", orDash(meta.Ref))
+	fmt.Fprintf(w, "Sha:\t%s\n", orDash(meta.Sha))
+	fmt.Fprintf(w, "Commit:\t%s\n", orDash(meta.Commit))
+	fmt.Fprintf(w, "Size:\t%s\n", formatSize(meta.Size))
+	fmt.Fprintf(w, "Created:\t%s\n", meta.Created.Local().Format(time.RFC3339))
+	fmt.Fprintf(w, "Last used:\t%s\n", meta.LastUsed.Local().Format(time.RFC3339))
+	fmt.Fprintf(w, "Integrity:\t%s\n", status)
+	return w.Flush()
+}
+
+func cacheVerify(c *cli.Context) error {
+	keys, err := cache.Entries()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, key := range keys {
+		if err := cache.Verify(filepath.Join(cache.Dir(), key)); err != nil {
+			failed++
+			fmt.Printf("failed  %s: %v\n", key, err)
+			continue
+		}
+		fmt.Printf("ok      %s\n", key)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d cached actions failed verification", failed, len(keys))
+	}
+	return nil
+}
+
+func cachePrune(c *cli.Context) error {
+	maxSize, err := utils.ParseSize(c.String("max-size"))
+	if err != nil {
+		return err
+	}
+	opts := cache.EvictOptions{TTL: c.Duration("older-than"), MaxSize: maxSize}
+	if !opts.Enabled() {
+		return errors.New("one of --older-than or --max-size must be set")
+	}
+
+	abandoned, err := cache.Recover(cache.AbandonedAge)
+	if err != nil {
+		return err
+	}
+	for _, path := range abandoned {
+		fmt.Printf("removed abandoned %s\n", filepath.Base(path))
+	}
+
+	removed, err := cache.Evict(opts)
+	for _, key := range removed {
+		fmt.Printf("removed %s\n", filepath.Base(key))
+	}
+	return err
+}
+
+func cacheExport(c *cli.Context) error {
+	var entries []cache.BundleEntry
+	for _, uses := range c.Args() {
+		repo, ref, ok := utils.ParseLookup(uses)
+		if !ok {
+			return fmt.Errorf("invalid 'uses' format: %s", uses)
+		}
+		key, err := cacheKey(c, repo, ref)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cache.BundleEntry{
+			Key:  filepath.Base(key),
+			Name: fmt.Sprintf("%s@%s", repo, ref),
+		})
+	}
+	if len(entries) == 0 {
+		keys, err := cache.Entries()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			entry := cache.BundleEntry{Key: key}
+			if meta, err := cache.ReadMetadata(filepath.Join(cache.Dir(), key)); err == nil && meta.Repo != "" {
+				entry.Name = fmt.Sprintf("%s@%s", meta.Repo, meta.Ref)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	f, err := os.Create(c.String("output"))
+	if err != nil {
+		return errors.Wrap(err, "failed to create bundle")
+	}
+	defer f.Close()
+	if err := cache.Export(context.Background(), f, entries); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write bundle")
+	}
+	fmt.Printf("exported %d cached actions to %s\n", len(entries), c.String("output"))
+	return nil
+}
+
+func cacheImport(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("path of the bundle to import must be set")
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "failed to open bundle")
+	}
+	defer f.Close()
+
+	manifest, err := cache.Import(context.Background(), f)
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest.Entries {
+		fmt.Printf("imported %s %s\n", entry.Key, entry.Name)
+	}
+	return nil
+}
+
+// cacheKey returns the key of the cache entry of the repository, as
+// fetched with the clone method and options of the command.
+func cacheKey(c *cli.Context, repo, ref string) (string, error) {
+	base, err := cloner.NewMethod(c.GlobalString("clone-method"), io.Discard, cloneOptions(c))
+	if err != nil {
+		return "", err
+	}
+	return cloner.CacheKey(base, repo, ref, ""), nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatSize formats a size in bytes in human readable form.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone-plugins/drone-github-actions/cache"
+	"github.com/drone-plugins/drone-github-actions/cloner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheInspectVariant(t *testing.T) {
+	dir := t.TempDir()
+	cache.SetDir(dir)
+	t.Cleanup(func() { cache.SetDir("") })
+
+	// seed the entry a runner cloning submodules adds
+	repo := "https://github.com/actions/checkout"
+	submodules := cloner.NewWithOptions(1, io.Discard, cloner.Options{Submodules: true})
+	key := cloner.CacheKey(submodules, repo, "v4", "")
+	require.NoError(t, cache.Add(context.Background(), key, &cache.Metadata{Repo: repo, Ref: "v4"}, func(data string) error {
+		return os.WriteFile(filepath.Join(data, "action.yml"), []byte("name: checkout"), 0644)
+	}))
+
+	err := newApp().Run([]string{"plugin", "--cache-dir", dir, "--clone-submodules", "cache", "inspect", "actions/checkout@v4"})
+	assert.NoError(t, err)
+
+	// the plain clone is a different entry
+	err = newApp().Run([]string{"plugin", "--cache-dir", dir, "cache", "inspect", "actions/checkout@v4"})
+	assert.ErrorContains(t, err, "is not present in the action cache")
+}
+
+func TestCacheExportVariant(t *testing.T) {
+	dir := t.TempDir()
+	cache.SetDir(dir)
+	t.Cleanup(func() { cache.SetDir("") })
+
+	repo := "https://github.com/actions/checkout"
+	lfs := cloner.NewWithOptions(1, io.Discard, cloner.Options{LFS: true})
+	key := cloner.CacheKey(lfs, repo, "v4", "")
+	require.NoError(t, cache.Add(context.Background(), key, &cache.Metadata{Repo: repo, Ref: "v4"}, func(data string) error {
+		return os.WriteFile(filepath.Join(data, "action.yml"), []byte("name: checkout"), 0644)
+	}))
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	err := newApp().Run([]string{"plugin", "--cache-dir", dir, "--clone-lfs", "cache", "export", "-o", bundle, "actions/checkout@v4"})
+	require.NoError(t, err)
+
+	// the bundle seeds the entry a runner fetching lfs files uses
+	seeded := t.TempDir()
+	err = newApp().Run([]string{"plugin", "--cache-dir", seeded, "cache", "import", bundle})
+	require.NoError(t, err)
+	assert.True(t, cache.Exists(filepath.Join(seeded, filepath.Base(key))))
+
+	err = newApp().Run([]string{"plugin", "--cache-dir", dir, "cache", "export", "-o", bundle, "actions/checkout@v4"})
+	assert.ErrorContains(t, err, "is not present in the cache")
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"time"
 
 	plugin "github.com/drone-plugins/drone-github-actions"
+	"github.com/drone-plugins/drone-github-actions/cache"
+	"github.com/drone-plugins/drone-github-actions/cloner"
 	"github.com/drone-plugins/drone-github-actions/daemon"
 	"github.com/drone-plugins/drone-github-actions/pkg/encoder"
+	"github.com/drone-plugins/drone-github-actions/policy"
+	"github.com/drone-plugins/drone-github-actions/utils"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -44,11 +49,28 @@ func main() {
 		}
 	}
 
+	app := newApp()
+	if err := app.Run(os.Args); err != nil {
+		logrus.Error(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// newApp returns the command line application of the plugin.
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "drone github actions plugin"
 	app.Usage = "drone github actions plugin"
 	app.Action = run
 	app.Version = version
+	app.Before = func(c *cli.Context) error {
+		cache.SetDir(c.GlobalString("cache-dir"))
+		return nil
+	}
+	app.Commands = []cli.Command{
+		cacheCommand,
+		prefetchCommand,
+	}
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "action-name",
@@ -72,10 +94,25 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "action-image",
-			Usage:  "Image to use for running github actions",
-			Value:  "node:16-buster-slim",
+			Usage:  "Image to use for running github actions, selected from the runtime of the action by default",
 			EnvVar: "PLUGIN_ACTION_IMAGE",
 		},
+		cli.StringFlag{
+			Name:   "action-runs-on",
+			Usage:  "Runner label of the job running the action",
+			Value:  "ubuntu-latest",
+			EnvVar: "PLUGIN_RUNS_ON",
+		},
+		cli.StringFlag{
+			Name:   "action-platforms",
+			Usage:  "Images to use for runner labels, e.g. {\"ubuntu-22.04\": \"catthehacker/ubuntu:act-22.04\"}",
+			EnvVar: "PLUGIN_PLATFORMS",
+		},
+		cli.StringFlag{
+			Name:   "action-runtime-images",
+			Usage:  "Images to use for action runtimes, e.g. {\"node20\": \"node:20\", \"composite\": \"ubuntu:22.04\"}",
+			EnvVar: "PLUGIN_RUNTIME_IMAGES",
+		},
 		cli.StringFlag{
 			Name:   "event-payload",
 			Usage:  "Webhook event payload",
@@ -86,13 +123,147 @@ func main() {
 			Usage:  "User that triggered the event",
 			EnvVar: "PLUGIN_ACTOR",
 		},
+		cli.StringFlag{
+			Name:   "policy",
+			Usage:  "Path to the policy file restricting which actions can run",
+			EnvVar: "PLUGIN_POLICY",
+		},
+		cli.BoolFlag{
+			Name:   "offline",
+			Usage:  "Use only the local action cache and never access the network to fetch the action",
+			EnvVar: "PLUGIN_OFFLINE",
+		},
+		cli.BoolTFlag{
+			Name:   "strict",
+			Usage:  "Fail the step if the action reference is invalid or the action cannot be cloned",
+			EnvVar: "PLUGIN_STRICT",
+		},
+		cli.StringFlag{
+			Name:   "clone-method",
+			Usage:  "Method used to fetch actions: git, or tarball or zipball to download an archive of the commit",
+			Value:  "git",
+			EnvVar: "PLUGIN_CLONE_METHOD",
+		},
+		cli.BoolFlag{
+			Name:   "clone-submodules",
+			Usage:  "Clone the submodules of actions recursively",
+			EnvVar: "PLUGIN_SUBMODULES",
+		},
+		cli.BoolFlag{
+			Name:   "clone-lfs",
+			Usage:  "Replace Git LFS pointer files of actions with their contents",
+			EnvVar: "PLUGIN_LFS",
+		},
+		cli.StringFlag{
+			Name:   "ref-preference",
+			Usage:  "Reference used if the ref in 'uses' is both a tag and a branch: tag or branch",
+			Value:  cloner.PreferTag,
+			EnvVar: "PLUGIN_REF_PREFERENCE",
+		},
+		cli.StringFlag{
+			Name:   "cache-dir",
+			Usage:  "Directory of the action cache, defaults to $HOME/.cache",
+			EnvVar: "PLUGIN_CACHE_DIR",
+		},
+		cli.DurationFlag{
+			Name:   "cache-ttl",
+			Usage:  "Evict cached actions not used for longer than the duration",
+			EnvVar: "PLUGIN_CACHE_TTL",
+		},
+		cli.DurationFlag{
+			Name:   "cache-freshness",
+			Usage:  "Interval after which actions cached from a branch or tag are checked for updates, 0 disables the check",
+			Value:  time.Hour,
+			EnvVar: "PLUGIN_CACHE_FRESHNESS",
+		},
+		cli.DurationFlag{
+			Name:   "cache-lock-timeout",
+			Usage:  "Time to wait for another step adding the same action to the cache before cloning it outside of the cache, 0 waits indefinitely",
+			Value:  5 * time.Minute,
+			EnvVar: "PLUGIN_CACHE_LOCK_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "cache-max-size",
+			Usage:  "Evict least recently used cached actions when the cache exceeds the size, e.g. 2GB",
+			EnvVar: "PLUGIN_CACHE_MAX_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "cache-remote",
+			Usage:  "Remote action cache shared between runners, a directory or http(s) URL of an HTTP cache or S3-compatible bucket",
+			EnvVar: "PLUGIN_CACHE_REMOTE",
+		},
+		cli.StringFlag{
+			Name:   "cache-remote-token",
+			Usage:  "Bearer token of the remote action cache",
+			EnvVar: "PLUGIN_CACHE_REMOTE_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "cache-remote-access-key",
+			Usage:  "S3 access key of the remote action cache",
+			EnvVar: "PLUGIN_CACHE_REMOTE_ACCESS_KEY,AWS_ACCESS_KEY_ID",
+		},
+		cli.StringFlag{
+			Name:   "cache-remote-secret-key",
+			Usage:  "S3 secret key of the remote action cache",
+			EnvVar: "PLUGIN_CACHE_REMOTE_SECRET_KEY,AWS_SECRET_ACCESS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "cache-remote-region",
+			Usage:  "S3 region of the remote action cache",
+			Value:  "us-east-1",
+			EnvVar: "PLUGIN_CACHE_REMOTE_REGION,AWS_REGION",
+		},
+
+		// image flags
+		cli.StringFlag{
+			Name:   "images.archive",
+			Usage:  "image archive in the workspace loaded into the docker daemon before the action runs",
+			EnvVar: "PLUGIN_IMAGES_ARCHIVE",
+		},
+		cli.BoolFlag{
+			Name:   "images.save",
+			Usage:  "save the images of the docker daemon to the image archive after the action ran",
+			EnvVar: "PLUGIN_IMAGES_SAVE",
+		},
+		cli.StringSliceFlag{
+			Name:   "images.pull",
+			Usage:  "images pulled in parallel while the action is cloned",
+			EnvVar: "PLUGIN_IMAGES_PULL",
+		},
 
 		// daemon flags
+		cli.StringFlag{
+			Name:   "daemon.mode",
+			Usage:  "docker daemon mode, daemon to start a daemon in the step or host-socket to use the mounted socket of the host",
+			Value:  "daemon",
+			EnvVar: "PLUGIN_DAEMON_MODE",
+		},
+		cli.StringFlag{
+			Name:   "daemon.engine",
+			Usage:  "container engine running the actions, docker or podman",
+			Value:  "docker",
+			EnvVar: "PLUGIN_DAEMON_ENGINE",
+		},
 		cli.StringFlag{
 			Name:   "docker.registry",
 			Usage:  "docker daemon registry",
 			Value:  "https://index.docker.io/v1/",
-			EnvVar: "PLUGIN_DAEMON_REGISTRY",
+			EnvVar: "PLUGIN_DAEMON_REGISTRY,PLUGIN_DOCKER_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "docker.username",
+			Usage:  "docker registry username",
+			EnvVar: "PLUGIN_DOCKER_USERNAME,DOCKER_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "docker.password",
+			Usage:  "docker registry password",
+			EnvVar: "PLUGIN_DOCKER_PASSWORD,DOCKER_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "docker.config",
+			Usage:  "docker config.json with credentials of more registries and credential helpers",
+			EnvVar: "PLUGIN_DOCKER_CONFIG",
 		},
 		cli.StringFlag{
 			Name:   "daemon.mirror",
@@ -145,21 +316,93 @@ func main() {
 			Usage:  "docker daemon Experimental mode",
 			EnvVar: "PLUGIN_DAEMON_EXPERIMENTAL",
 		},
+		cli.StringFlag{
+			Name:   "daemon.config",
+			Usage:  "docker daemon configuration rendered into daemon.json, JSON object",
+			EnvVar: "PLUGIN_DAEMON_CONFIG",
+		},
 		cli.BoolFlag{
 			Name:   "daemon.debug",
 			Usage:  "docker daemon executes in debug mode",
 			EnvVar: "PLUGIN_DAEMON_DEBUG",
 		},
+		cli.StringFlag{
+			Name:   "daemon.log-file",
+			Usage:  "file the docker daemon output is written to, e.g. a workspace artifact",
+			EnvVar: "PLUGIN_DAEMON_LOG_FILE",
+		},
 		cli.BoolFlag{
 			Name:   "daemon.off",
 			Usage:  "don't start the docker daemon",
 			EnvVar: "PLUGIN_DAEMON_OFF",
 		},
+		cli.DurationFlag{
+			Name:   "daemon.start-timeout",
+			Usage:  "time to wait for the docker daemon to be ready",
+			Value:  time.Minute,
+			EnvVar: "PLUGIN_DAEMON_START_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "daemon.host",
+			Usage:  "address of a running docker daemon to use, tcp://, ssh:// or unix://",
+			EnvVar: "PLUGIN_DAEMON_HOST",
+		},
+		cli.BoolFlag{
+			Name:   "daemon.tls-verify",
+			Usage:  "verify the certificate of the docker daemon host",
+			EnvVar: "PLUGIN_DAEMON_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-ca-cert",
+			Usage:  "CA certificate of the docker daemon host, PEM contents or path",
+			EnvVar: "PLUGIN_DAEMON_TLS_CA_CERT",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-cert",
+			Usage:  "client certificate for the docker daemon host, PEM contents or path",
+			EnvVar: "PLUGIN_DAEMON_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-key",
+			Usage:  "client key for the docker daemon host, PEM contents or path",
+			EnvVar: "PLUGIN_DAEMON_TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "daemon.ssh-key",
+			Usage:  "private key for the ssh:// docker daemon host, PEM contents or path",
+			EnvVar: "PLUGIN_DAEMON_SSH_KEY",
+		},
+		cli.StringFlag{
+			Name:   "daemon.ssh-known-hosts",
+			Usage:  "known hosts entries of the ssh:// docker daemon host",
+			EnvVar: "PLUGIN_DAEMON_SSH_KNOWN_HOSTS",
+		},
+		cli.BoolFlag{
+			Name:   "daemon.rootless",
+			Usage:  "run a rootless docker daemon, or use the running daemon of the user",
+			EnvVar: "PLUGIN_DAEMON_ROOTLESS",
+		},
 	}
+	return app
+}
 
-	if err := app.Run(os.Args); err != nil {
-		logrus.Fatal(err)
+// exitCode returns a distinct exit code for errors with a well
+// known cause so that failures can be told apart by callers.
+func exitCode(err error) int {
+	var denied *policy.DeniedError
+	switch {
+	case errors.Is(err, utils.ErrInvalidReference):
+		return 2
+	case errors.Is(err, cloner.ErrAuth):
+		return 3
+	case errors.Is(err, cloner.ErrRepoNotFound), errors.Is(err, cloner.ErrRefNotFound):
+		return 4
+	case errors.Is(err, cloner.ErrNetwork):
+		return 5
+	case errors.As(err, &denied):
+		return 6
 	}
+	return 1
 }
 
 func run(c *cli.Context) error {
@@ -176,6 +419,25 @@ func run(c *cli.Context) error {
 		return errors.Wrap(err, "env attribute is not of map type with key & value as string")
 	}
 
+	platforms, err := strToMap(c.String("action-platforms"))
+	if err != nil {
+		return errors.Wrap(err, "platforms attribute is not of map type with key & value as string")
+	}
+	runtimeImages, err := strToMap(c.String("action-runtime-images"))
+	if err != nil {
+		return errors.Wrap(err, "runtime_images attribute is not of map type with key & value as string")
+	}
+
+	cacheMaxSize, err := utils.ParseSize(c.String("cache-max-size"))
+	if err != nil {
+		return errors.Wrap(err, "cache-max-size attribute is not a valid size")
+	}
+
+	cacheRemote, err := remoteCache(c)
+	if err != nil {
+		return err
+	}
+
 	plugin := plugin.Plugin{
 		Action: plugin.Action{
 			Uses:         c.String("action-name"),
@@ -183,11 +445,19 @@ func run(c *cli.Context) error {
 			Env:          actionEnv,
 			Verbose:      c.Bool("action-verbose"),
 			Image:        c.String("action-image"),
+			RunsOn:       c.String("action-runs-on"),
+			Platforms:    platforms,
+			Runtimes:     runtimeImages,
 			EventPayload: c.String("event-payload"),
 			Actor:        c.String("actor"),
 		},
 		Daemon: daemon.Daemon{
+			Mode:          c.String("daemon.mode"),
+			Engine:        c.String("daemon.engine"),
 			Registry:      c.String("docker.registry"),
+			Username:      c.String("docker.username"),
+			Password:      c.String("docker.password"),
+			Config:        c.String("docker.config"),
 			Mirror:        c.String("daemon.mirror"),
 			StorageDriver: c.String("daemon.storage-driver"),
 			StoragePath:   c.String("daemon.storage-path"),
@@ -195,16 +465,68 @@ func run(c *cli.Context) error {
 			Disabled:      c.Bool("daemon.off"),
 			IPv6:          c.Bool("daemon.ipv6"),
 			Debug:         c.Bool("daemon.debug"),
+			LogFile:       c.String("daemon.log-file"),
 			Bip:           c.String("daemon.bip"),
 			DNS:           c.StringSlice("daemon.dns"),
 			DNSSearch:     c.StringSlice("daemon.dns-search"),
 			MTU:           c.String("daemon.mtu"),
 			Experimental:  c.Bool("daemon.experimental"),
+			DaemonJSON:    c.String("daemon.config"),
+			Rootless:      c.Bool("daemon.rootless"),
+			Host:          c.String("daemon.host"),
+			TLSVerify:     c.Bool("daemon.tls-verify"),
+			TLSCACert:     c.String("daemon.tls-ca-cert"),
+			TLSCert:       c.String("daemon.tls-cert"),
+			TLSKey:        c.String("daemon.tls-key"),
+			SSHKey:        c.String("daemon.ssh-key"),
+			SSHKnownHosts: c.String("daemon.ssh-known-hosts"),
+			StartTimeout:  c.Duration("daemon.start-timeout"),
+		},
+		Images: daemon.Images{
+			Archive: c.String("images.archive"),
+			Save:    c.Bool("images.save"),
+			Pull:    c.StringSlice("images.pull"),
+		},
+		Policy:       c.String("policy"),
+		Offline:      c.Bool("offline"),
+		Strict:       c.BoolT("strict"),
+		CloneMethod:  c.String("clone-method"),
+		CloneOptions: cloneOptions(c),
+		CacheEviction: cache.EvictOptions{
+			TTL:     c.Duration("cache-ttl"),
+			MaxSize: cacheMaxSize,
 		},
+		CacheFreshness:   c.Duration("cache-freshness"),
+		CacheLockTimeout: c.Duration("cache-lock-timeout"),
+		CacheRemote:      cacheRemote,
 	}
 	return plugin.Exec()
 }
 
+// cloneOptions returns the optional features used to fetch actions.
+func cloneOptions(c *cli.Context) cloner.Options {
+	return cloner.Options{
+		Submodules:    c.GlobalBool("clone-submodules"),
+		LFS:           c.GlobalBool("clone-lfs"),
+		RefPreference: c.GlobalString("ref-preference"),
+	}
+}
+
+// remoteCache returns the remote action cache backend, or nil if no
+// remote cache is configured.
+func remoteCache(c *cli.Context) (cache.Backend, error) {
+	location := c.GlobalString("cache-remote")
+	if location == "" {
+		return nil, nil
+	}
+	return cache.NewBackend(location, cache.BackendOptions{
+		Token:     c.GlobalString("cache-remote-token"),
+		AccessKey: c.GlobalString("cache-remote-access-key"),
+		SecretKey: c.GlobalString("cache-remote-secret-key"),
+		Region:    c.GlobalString("cache-remote-region"),
+	})
+}
+
 func strToMap(s string) (map[string]string, error) {
 	m := make(map[string]string)
 	if s == "" {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/drone-plugins/drone-github-actions/cloner"
+	"github.com/drone-plugins/drone-github-actions/prefetch"
+	"github.com/drone-plugins/drone-github-actions/utils"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var prefetchCommand = cli.Command{
+	Name:      "prefetch",
+	Usage:     "clone actions into the local action cache",
+	ArgsUsage: "[uses...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "pipeline",
+			Usage: "drone pipeline file to scan for steps using this plugin",
+		},
+		cli.StringSliceFlag{
+			Name:  "image",
+			Usage: "plugin image used by the pipeline steps to prefetch",
+			Value: &cli.StringSlice{"plugins/github-actions"},
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of actions cloned in parallel",
+			Value: 4,
+		},
+	},
+	Action: prefetchActions,
+}
+
+func prefetchActions(c *cli.Context) error {
+	uses := []string(c.Args())
+	if file := c.String("pipeline"); file != "" {
+		found, err := utils.ParsePipelineUses(file, c.StringSlice("image"))
+		if err != nil {
+			return err
+		}
+		uses = append(uses, found...)
+	}
+	if len(uses) == 0 {
+		return errors.New("no actions to prefetch, pass uses references or a pipeline file")
+	}
+
+	remote, err := remoteCache(c)
+	if err != nil {
+		return err
+	}
+
+	// progress of parallel clones would interleave, discard it
+	base, err := cloner.NewMethod(c.GlobalString("clone-method"), io.Discard, cloneOptions(c))
+	if err != nil {
+		return err
+	}
+	clone := cloner.NewCache(base).WithRemote(remote)
+	defer clone.Cleanup()
+	results := prefetch.Run(context.Background(), clone, uses, c.Int("concurrency"))
+
+	failed := 0
+	for _, r := range results {
+		name := r.Uses
+		if r.Parent != "" {
+			name = fmt.Sprintf("%s (used by %s)", r.Uses, r.Parent)
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Printf("failed  %s: %v\n", name, r.Err)
+			continue
+		}
+		fmt.Printf("cached  %s in %s\n", name, r.Dir)
+	}
+	if failed != 0 {
+		return fmt.Errorf("failed to prefetch %d of %d actions", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dockerHubRegistry is the key of the Docker Hub credentials in docker
+// configs.
+const dockerHubRegistry = "https://index.docker.io/v1/"
+
+// WriteAuthConfig writes a docker config with the registry credentials
+// of the daemon configuration, merged into the given docker config which
+// may contain credentials of more registries and credential helpers, and
+// points DOCKER_CONFIG at it so that act and the docker cli pull images
+// with them. The returned function removes the config.
+func WriteAuthConfig(d Daemon) (func(), error) {
+	noop := func() {}
+	if d.Username == "" && d.Config == "" {
+		return noop, nil
+	}
+	config, err := authConfig(d)
+	if err != nil {
+		return noop, err
+	}
+	data, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return noop, err
+	}
+
+	dir, err := ioutil.TempDir("", "docker-config-")
+	if err != nil {
+		return noop, err
+	}
+	remove := func() { os.RemoveAll(dir) }
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		remove()
+		return noop, err
+	}
+	if err := os.Setenv("DOCKER_CONFIG", dir); err != nil {
+		remove()
+		return noop, err
+	}
+	return remove, nil
+}
+
+// authConfig returns the docker config of the daemon configuration,
+// with the keys of the given docker config preserved.
+func authConfig(d Daemon) (map[string]json.RawMessage, error) {
+	config := map[string]json.RawMessage{}
+	if d.Config != "" {
+		data := []byte(d.Config)
+		if !strings.HasPrefix(strings.TrimSpace(d.Config), "{") {
+			var err error
+			if data, err = ioutil.ReadFile(d.Config); err != nil {
+				return nil, fmt.Errorf("failed to read docker config: %v", err)
+			}
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid docker config: %v", err)
+		}
+	}
+
+	if d.Username != "" {
+		if d.Password == "" {
+			return nil, errors.New("docker registry username requires a password")
+		}
+		auths := map[string]json.RawMessage{}
+		if raw, ok := config["auths"]; ok {
+			if err := json.Unmarshal(raw, &auths); err != nil {
+				return nil, fmt.Errorf("invalid docker config auths: %v", err)
+			}
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(d.Username + ":" + d.Password))
+		auths[registryKey(d.Registry)], _ = json.Marshal(map[string]string{"auth": auth})
+		config["auths"], _ = json.Marshal(auths)
+	}
+
+	if err := checkCredentialHelpers(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// checkCredentialHelpers returns an error if a credential helper the
+// docker config uses is not installed.
+func checkCredentialHelpers(config map[string]json.RawMessage) error {
+	helpers := map[string]string{}
+	if raw, ok := config["credHelpers"]; ok {
+		if err := json.Unmarshal(raw, &helpers); err != nil {
+			return fmt.Errorf("invalid docker config credHelpers: %v", err)
+		}
+	}
+	if raw, ok := config["credsStore"]; ok {
+		var store string
+		if err := json.Unmarshal(raw, &store); err != nil {
+			return fmt.Errorf("invalid docker config credsStore: %v", err)
+		}
+		helpers["all registries"] = store
+	}
+
+	registries := make([]string, 0, len(helpers))
+	for registry := range helpers {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	for _, registry := range registries {
+		exe := "docker-credential-" + helpers[registry]
+		if _, err := exec.LookPath(exe); err != nil {
+			return fmt.Errorf("credential helper %s for %s not found, install it in the image", exe, registry)
+		}
+	}
+	return nil
+}
+
+// registryKey returns the key of the registry credentials in docker
+// configs, the host of the registry or the Docker Hub key.
+func registryKey(registry string) string {
+	host := registry
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	}
+	return host
+}
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAuthConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", "")
+	remove, err := WriteAuthConfig(Daemon{
+		Registry: "https://ghcr.io",
+		Username: "octocat",
+		Password: "secret",
+		Config:   `{"auths": {"https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="}}, "experimental": "enabled"}`,
+	})
+	require.NoError(t, err)
+	dir := os.Getenv("DOCKER_CONFIG")
+	require.NotEmpty(t, dir)
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	var config struct {
+		Auths        map[string]map[string]string `json:"auths"`
+		Experimental string                       `json:"experimental"`
+	}
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, map[string]map[string]string{
+		"https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="},
+		"ghcr.io":                     {"auth": "b2N0b2NhdDpzZWNyZXQ="},
+	}, config.Auths)
+	assert.Equal(t, "enabled", config.Experimental)
+
+	remove()
+	assert.NoDirExists(t, dir)
+}
+
+func TestAuthConfigErrors(t *testing.T) {
+	for name, d := range map[string]Daemon{
+		"password":      {Username: "octocat"},
+		"invalid":       {Config: "{auths"},
+		"missing-file":  {Config: filepath.Join(t.TempDir(), "config.json")},
+		"helper":        {Config: `{"credHelpers": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "missing-helper"}}`},
+		"store":         {Config: `{"credsStore": "missing-store"}`},
+		"invalid-auths": {Username: "octocat", Password: "secret", Config: `{"auths": []}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := authConfig(d)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRegistryKey(t *testing.T) {
+	for registry, key := range map[string]string{
+		"":                             dockerHubRegistry,
+		"https://index.docker.io/v1/":  dockerHubRegistry,
+		"docker.io":                    dockerHubRegistry,
+		"ghcr.io":                      "ghcr.io",
+		"https://ghcr.io/":             "ghcr.io",
+		"registry.example.com:5000/v2": "registry.example.com:5000",
+	} {
+		assert.Equal(t, key, registryKey(registry), registry)
+	}
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// seccompProfile is the seccomp profile of the docker image, used unless
+// the daemon configuration sets another one.
+const seccompProfile = "/etc/docker/default.json"
+
+// daemonConfig returns the daemon.json of the daemon, rendering the
+// daemon settings into the structured daemon configuration. The data
+// root is omitted if empty.
+func daemonConfig(d Daemon, dataRoot string) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	if strings.TrimSpace(d.DaemonJSON) != "" {
+		if err := validateDaemonJSON(d.DaemonJSON); err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(strings.NewReader(d.DaemonJSON))
+		dec.UseNumber()
+		if err := dec.Decode(&config); err != nil {
+			return nil, fmt.Errorf("invalid daemon configuration: %v", err)
+		}
+	}
+	if _, ok := config["hosts"]; ok {
+		return nil, errors.New("hosts cannot be set in the daemon configuration, set daemon.host to use a running daemon instead")
+	}
+
+	var conflict error
+	set := func(key string, value interface{}, setting string) {
+		if prev, ok := config[key]; ok && !equalJSON(prev, value) && conflict == nil {
+			conflict = fmt.Errorf("daemon.%s conflicts with %s in the daemon configuration", setting, key)
+		}
+		config[key] = value
+	}
+	add := func(key string, values ...string) {
+		list, _ := config[key].([]interface{})
+		for _, v := range values {
+			if !containsJSON(list, v) {
+				list = append(list, v)
+			}
+		}
+		config[key] = list
+	}
+
+	if dataRoot != "" {
+		set("data-root", dataRoot, "storage-path")
+	}
+	if d.StorageDriver != "" {
+		set("storage-driver", d.StorageDriver, "storage-driver")
+	}
+	if d.Insecure && d.Registry != "" {
+		add("insecure-registries", d.Registry)
+	}
+	if d.IPv6 {
+		set("ipv6", true, "ipv6")
+	}
+	if d.Mirror != "" {
+		add("registry-mirrors", d.Mirror)
+	}
+	if d.Bip != "" {
+		set("bip", d.Bip, "bip")
+	}
+	if len(d.DNS) != 0 {
+		add("dns", d.DNS...)
+	}
+	if len(d.DNSSearch) != 0 {
+		add("dns-search", d.DNSSearch...)
+	}
+	if d.MTU != "" {
+		mtu, err := strconv.Atoi(d.MTU)
+		if err != nil || mtu <= 0 {
+			return nil, fmt.Errorf("invalid daemon.mtu %q", d.MTU)
+		}
+		set("mtu", mtu, "mtu")
+	}
+	if d.Experimental {
+		set("experimental", true, "experimental")
+	}
+	if conflict != nil {
+		return nil, conflict
+	}
+
+	if _, ok := config["seccomp-profile"]; !ok {
+		if _, err := os.Stat(seccompProfile); err == nil {
+			config["seccomp-profile"] = seccompProfile
+		}
+	}
+	return config, nil
+}
+
+// validateDaemonJSON checks the types and values of the well-known keys
+// of the structured daemon configuration. Other keys are validated by
+// dockerd.
+func validateDaemonJSON(raw string) error {
+	var c struct {
+		RegistryMirrors     []string          `json:"registry-mirrors"`
+		InsecureRegistries  []string          `json:"insecure-registries"`
+		LogDriver           string            `json:"log-driver"`
+		LogOpts             map[string]string `json:"log-opts"`
+		DefaultAddressPools []struct {
+			Base string `json:"base"`
+			Size int    `json:"size"`
+		} `json:"default-address-pools"`
+		Features               map[string]bool `json:"features"`
+		MaxConcurrentDownloads *int            `json:"max-concurrent-downloads"`
+		MaxConcurrentUploads   *int            `json:"max-concurrent-uploads"`
+		MTU                    *int            `json:"mtu"`
+		Bip                    string          `json:"bip"`
+		DNS                    []string        `json:"dns"`
+		DNSSearch              []string        `json:"dns-search"`
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return fmt.Errorf("invalid daemon configuration: %v", err)
+	}
+
+	for _, mirror := range c.RegistryMirrors {
+		u, err := url.Parse(mirror)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid registry mirror %q in the daemon configuration, use an http or https URL", mirror)
+		}
+	}
+	for _, registry := range c.InsecureRegistries {
+		if strings.Contains(registry, "://") {
+			return fmt.Errorf("invalid insecure registry %q in the daemon configuration, use the host without scheme", registry)
+		}
+		if strings.Contains(registry, "/") {
+			if _, _, err := net.ParseCIDR(registry); err != nil {
+				return fmt.Errorf("invalid insecure registry %q in the daemon configuration, use a host or CIDR", registry)
+			}
+		}
+	}
+	for _, pool := range c.DefaultAddressPools {
+		_, network, err := net.ParseCIDR(pool.Base)
+		if err != nil {
+			return fmt.Errorf("invalid default address pool base %q in the daemon configuration", pool.Base)
+		}
+		ones, bits := network.Mask.Size()
+		if pool.Size < ones || pool.Size > bits {
+			return fmt.Errorf("invalid default address pool size %d for base %s in the daemon configuration, use a prefix length between %d and %d", pool.Size, pool.Base, ones, bits)
+		}
+	}
+	for name, n := range map[string]*int{
+		"max-concurrent-downloads": c.MaxConcurrentDownloads,
+		"max-concurrent-uploads":   c.MaxConcurrentUploads,
+		"mtu":                      c.MTU,
+	} {
+		if n != nil && *n <= 0 {
+			return fmt.Errorf("invalid %s %d in the daemon configuration, use a positive number", name, *n)
+		}
+	}
+	if c.Bip != "" {
+		if _, _, err := net.ParseCIDR(c.Bip); err != nil {
+			return fmt.Errorf("invalid bip %q in the daemon configuration, use a CIDR", c.Bip)
+		}
+	}
+	for _, dns := range c.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("invalid dns server %q in the daemon configuration, use an IP address", dns)
+		}
+	}
+	if c.LogOpts != nil && c.LogDriver == "" {
+		return errors.New("log-opts in the daemon configuration require a log-driver")
+	}
+	return nil
+}
+
+// writeDaemonConfig writes the daemon.json of the daemon to a temporary
+// file and returns its path.
+func writeDaemonConfig(d Daemon, dataRoot string) (string, error) {
+	config, err := daemonConfig(d, dataRoot)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "daemon-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// checkDaemonConfig validates the daemon.json with dockerd, if it
+// supports validating configurations.
+func checkDaemonConfig(dockerd, path string) error {
+	out, err := exec.Command(dockerd, "--validate", "--config-file", path).CombinedOutput()
+	if err == nil || bytes.Contains(out, []byte("unknown flag")) {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		// dockerd could not be run, starting it reports why
+		return nil
+	}
+	return fmt.Errorf("invalid daemon configuration: %s", strings.TrimSpace(string(out)))
+}
+
+// equalJSON returns true if the values are encoded the same in JSON.
+func equalJSON(a, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(x, y)
+}
+
+// containsJSON returns true if the list contains a value encoded the same
+// in JSON as v.
+func containsJSON(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if equalJSON(item, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonConfig(t *testing.T) {
+	config, err := daemonConfig(Daemon{
+		Registry:      "registry.internal:5000",
+		Insecure:      true,
+		Mirror:        "https://mirror.gcr.io",
+		StorageDriver: "overlay2",
+		DNS:           []string{"1.1.1.1"},
+		MTU:           "1400",
+		Experimental:  true,
+		DaemonJSON: `{
+			"registry-mirrors": ["https://mirror.gcr.io", "https://mirror.example.com"],
+			"insecure-registries": ["10.0.0.0/8"],
+			"log-driver": "json-file",
+			"log-opts": {"max-size": "10m"},
+			"default-address-pools": [{"base": "172.30.0.0/16", "size": 24}],
+			"features": {"buildkit": true},
+			"max-concurrent-downloads": 10,
+			"mtu": 1400
+		}`,
+	}, "/var/lib/docker")
+	require.NoError(t, err)
+	delete(config, "seccomp-profile")
+
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"data-root": "/var/lib/docker",
+		"storage-driver": "overlay2",
+		"registry-mirrors": ["https://mirror.gcr.io", "https://mirror.example.com"],
+		"insecure-registries": ["10.0.0.0/8", "registry.internal:5000"],
+		"dns": ["1.1.1.1"],
+		"log-driver": "json-file",
+		"log-opts": {"max-size": "10m"},
+		"default-address-pools": [{"base": "172.30.0.0/16", "size": 24}],
+		"features": {"buildkit": true},
+		"max-concurrent-downloads": 10,
+		"mtu": 1400,
+		"experimental": true
+	}`, string(data))
+
+	config, err = daemonConfig(Daemon{}, "")
+	require.NoError(t, err)
+	assert.NotContains(t, config, "data-root")
+}
+
+func TestDaemonConfigErrors(t *testing.T) {
+	for name, d := range map[string]Daemon{
+		"syntax":        {DaemonJSON: `{"features": `},
+		"type":          {DaemonJSON: `{"features": {"buildkit": "yes"}}`},
+		"mirror":        {DaemonJSON: `{"registry-mirrors": ["mirror.gcr.io"]}`},
+		"insecure":      {DaemonJSON: `{"insecure-registries": ["http://registry.internal"]}`},
+		"pool-base":     {DaemonJSON: `{"default-address-pools": [{"base": "172.30.0.0", "size": 24}]}`},
+		"pool-size":     {DaemonJSON: `{"default-address-pools": [{"base": "172.30.0.0/16", "size": 8}]}`},
+		"downloads":     {DaemonJSON: `{"max-concurrent-downloads": 0}`},
+		"dns":           {DaemonJSON: `{"dns": ["dns.example.com"]}`},
+		"log-opts":      {DaemonJSON: `{"log-opts": {"max-size": "10m"}}`},
+		"hosts":         {DaemonJSON: `{"hosts": ["tcp://0.0.0.0:2375"]}`},
+		"conflict":      {Bip: "172.17.0.1/16", DaemonJSON: `{"bip": "172.18.0.1/16"}`},
+		"storage":       {StorageDriver: "vfs", DaemonJSON: `{"storage-driver": "overlay2"}`},
+		"mtu-setting":   {MTU: "large"},
+		"mirrors-value": {DaemonJSON: `{"registry-mirrors": "https://mirror.gcr.io"}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := daemonConfig(d, "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWriteDaemonConfig(t *testing.T) {
+	path, err := writeDaemonConfig(Daemon{DaemonJSON: `{"features": {"buildkit": true}}`}, "/data")
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, "/data", config["data-root"])
+	assert.Equal(t, map[string]interface{}{"buildkit": true}, config["features"])
+}
@@ -1,53 +1,116 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
 type Daemon struct {
+	Mode          string   // Daemon mode, daemon started in the step or host-socket
+	Engine        string   // Container engine, docker or podman
 	Registry      string   // Docker registry
+	Username      string   // Docker registry username
+	Password      string   // Docker registry password
+	Config        string   // Docker config.json with registry credentials, contents or path
 	Mirror        string   // Docker registry mirror
 	Insecure      bool     // Docker daemon enable insecure registries
 	StorageDriver string   // Docker daemon storage driver
 	StoragePath   string   // Docker daemon storage path
 	Disabled      bool     // Docker daemon is disabled (already running)
 	Debug         bool     // Docker daemon started in debug mode
+	LogFile       string   // Docker daemon log written to this file, e.g. in the workspace
 	Bip           string   // Docker daemon network bridge IP address
 	DNS           []string // Docker daemon dns server
 	DNSSearch     []string // Docker daemon dns search domain
 	MTU           string   // Docker daemon mtu setting
 	IPv6          bool     // Docker daemon IPv6 networking
 	Experimental  bool     // Docker daemon enable experimental mode
+	DaemonJSON    string   // Docker daemon configuration rendered into daemon.json, JSON object
+	Rootless      bool     // Docker daemon runs as the current user without privileges
+	Host          string   // Address of a running docker daemon to use instead
+	TLSVerify     bool     // Verify the certificate of the daemon at Host
+	TLSCACert     string   // CA certificate of the daemon at Host, PEM contents or path
+	TLSCert       string   // Client certificate for the daemon at Host, PEM contents or path
+	TLSKey        string   // Client key for the daemon at Host, PEM contents or path
+	SSHKey        string   // Private key for the ssh:// daemon at Host, PEM contents or path
+	SSHKnownHosts string   // Known hosts entries of the ssh:// daemon at Host
+
+	StartTimeout time.Duration // Time to wait for the daemon to be ready, 0 waits indefinitely
 }
 
-func StartDaemon(d Daemon) error {
-	if !d.Disabled {
-		startDaemon(d)
+// StartDaemon starts the container engine, points the environment at it
+// so that act and the docker commands use it, and waits until it is
+// ready or the start timeout expires. The returned engine is the handle
+// of the started daemon and must be stopped once it is no longer used.
+func StartDaemon(ctx context.Context, d Daemon) (Engine, error) {
+	engine, err := NewEngine(d)
+	if err != nil {
+		return nil, err
+	}
+	if err := engine.Start(); err != nil {
+		engine.Stop()
+		return nil, err
+	}
+	for _, env := range engine.Env() {
+		key, value, _ := strings.Cut(env, "=")
+		if err := os.Setenv(key, value); err != nil {
+			engine.Stop()
+			return nil, err
+		}
+	}
+
+	if d.StartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.StartTimeout)
+		defer cancel()
 	}
-	return waitForDaemon()
+	if err := engine.Wait(ctx); err != nil {
+		engine.Stop()
+		return nil, err
+	}
+	return engine, nil
 }
 
-func waitForDaemon() error {
-	// poll the docker daemon until it is started. This ensures the daemon is
-	// ready to accept connections before we proceed.
-	for i := 0; ; i++ {
-		cmd := commandInfo()
-		err := cmd.Run()
+// waitForDaemon probes the daemon the environment points to, or the
+// default daemon if env is empty, until it is ready to accept
+// connections. Probing stops early if the daemon process exits or the
+// context is done.
+func waitForDaemon(ctx context.Context, env []string, proc *process) error {
+	probe := newProbe(env)
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		err := probe(ctx)
 		if err == nil {
-			break
+			return nil
+		}
+		if exited, exitErr := proc.exited(); exited {
+			return proc.withLog(fmt.Errorf("daemon exited before it was ready: %v", exitErr))
 		}
-		if i == 15 {
-			fmt.Println("Unable to reach Docker Daemon after 15 attempts.")
-			return fmt.Errorf("failed to reach docker daemon after 15 attempts: %v", err)
+		select {
+		case <-ctx.Done():
+			return proc.withLog(fmt.Errorf("daemon was not ready in time: %v", err))
+		case <-ticker.C:
 		}
-		time.Sleep(time.Second * 1)
 	}
-	return nil
 }
 
-// helper function to create the docker info command.
-func commandInfo() *exec.Cmd {
-	return exec.Command(dockerExe, "info")
+// helper function to create the docker info command for the daemon the
+// environment points to, or the default daemon if env is empty.
+func commandInfo(ctx context.Context, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, dockerExe, "info")
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// trace writes each command to stdout with the command wrapped in an xml
+// tag so that it can be extracted and displayed in the logs.
+func trace(cmd *exec.Cmd) {
+	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
 }
@@ -4,73 +4,78 @@ package daemon
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
 const dockerExe = "/usr/local/bin/docker"
 const dockerdExe = "/usr/local/bin/dockerd"
+const defaultHost = "unix:///var/run/docker.sock"
 
-func startDaemon(daemon Daemon) {
-	cmd := commandDaemon(daemon)
-	if daemon.Debug {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		cmd.Stdout = ioutil.Discard
-		cmd.Stderr = ioutil.Discard
-	}
-	go func() {
-		trace(cmd)
-		cmd.Run()
-	}()
-}
-
-// helper function to create the docker daemon command.
-func commandDaemon(daemon Daemon) *exec.Cmd {
-	args := []string{
-		"--data-root", daemon.StoragePath,
-		"--host=unix:///var/run/docker.sock",
-	}
+// systemSSHConfig is the ssh configuration of the system, which is
+// ignored by ssh when a configuration file is given.
+const systemSSHConfig = "/etc/ssh/ssh_config"
 
-	if _, err := os.Stat("/etc/docker/default.json"); err == nil {
-		args = append(args, "--seccomp-profile=/etc/docker/default.json")
-	}
+// storage path of the rootful daemon, which rootless daemons cannot
+// write to. Rootless daemons use their default below $HOME instead.
+const rootfulStoragePath = "/var/lib/docker"
 
-	if daemon.StorageDriver != "" {
-		args = append(args, "-s", daemon.StorageDriver)
-	}
-	if daemon.Insecure && daemon.Registry != "" {
-		args = append(args, "--insecure-registry", daemon.Registry)
-	}
-	if daemon.IPv6 {
-		args = append(args, "--ipv6")
-	}
-	if len(daemon.Mirror) != 0 {
-		args = append(args, "--registry-mirror", daemon.Mirror)
+func (e *dockerEngine) Start() error {
+	if e.daemon.Rootless {
+		return e.startRootless()
 	}
-	if len(daemon.Bip) != 0 {
-		args = append(args, "--bip", daemon.Bip)
+	if e.daemon.Disabled {
+		return nil
 	}
-	for _, dns := range daemon.DNS {
-		args = append(args, "--dns", dns)
+	if err := e.writeConfig(dockerdExe, e.daemon.StoragePath); err != nil {
+		return err
 	}
-	for _, dnsSearch := range daemon.DNSSearch {
-		args = append(args, "--dns-search", dnsSearch)
+	proc, err := startProcess(commandDaemon(e.configFile), e.daemon)
+	if err != nil {
+		return fmt.Errorf("failed to start docker daemon: %v", err)
 	}
-	if len(daemon.MTU) != 0 {
-		args = append(args, "--mtu", daemon.MTU)
+	e.host, e.proc = defaultHost, proc
+	return nil
+}
+
+// writeConfig writes the daemon.json of the daemon and validates it
+// with the dockerd executable.
+func (e *dockerEngine) writeConfig(dockerd, dataRoot string) error {
+	path, err := writeDaemonConfig(e.daemon, dataRoot)
+	if err != nil {
+		return err
 	}
-	if daemon.Experimental {
-		args = append(args, "--experimental")
+	e.configFile = path
+	return checkDaemonConfig(dockerd, path)
+}
+
+// helper function to create the docker daemon command.
+func commandDaemon(configFile string) *exec.Cmd {
+	return exec.Command(dockerdExe,
+		"--config-file", configFile,
+		"--host=unix:///var/run/docker.sock",
+	)
+}
+
+// rootlessRuntimeDir returns the directory of the sockets and state of
+// rootless daemons, XDG_RUNTIME_DIR if set.
+func rootlessRuntimeDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("docker-%d", os.Getuid()))
 	}
-	return exec.Command(dockerdExe, args...)
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// sshWrapper returns the name and contents of a script running the ssh
+// client with the configuration file.
+func sshWrapper(ssh, config string) (string, []byte) {
+	return "ssh", []byte(fmt.Sprintf("#!/bin/sh\nexec %s -F %s \"$@\"\n", shellQuote(ssh), shellQuote(config)))
 }
 
-// trace writes each command to stdout with the command wrapped in an xml
-// tag so that it can be extracted and displayed in the logs.
-func trace(cmd *exec.Cmd) {
-	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
@@ -2,10 +2,34 @@
 
 package daemon
 
+import (
+	"errors"
+	"fmt"
+)
+
 const dockerExe = "C:\\bin\\docker.exe"
 const dockerdExe = ""
+const defaultHost = "npipe:////./pipe/docker_engine"
 const dockerHome = "C:\\ProgramData\\docker\\"
 
-func startDaemon(daemon Daemon) {
-	// this is a no-op on windows
+// systemSSHConfig is the ssh configuration of the system, which is
+// ignored by ssh when a configuration file is given.
+const systemSSHConfig = "C:/ProgramData/ssh/ssh_config"
+
+func (e *dockerEngine) Start() error {
+	if e.daemon.Rootless {
+		return e.startRootless()
+	}
+	// starting the daemon is a no-op on windows
+	return nil
+}
+
+func newPodman(d Daemon) (Engine, error) {
+	return nil, errors.New("podman is not supported on windows")
+}
+
+// sshWrapper returns the name and contents of a batch file running the
+// ssh client with the configuration file.
+func sshWrapper(ssh, config string) (string, []byte) {
+	return "ssh.cmd", []byte(fmt.Sprintf("@\"%s\" -F \"%s\" %%*\r\n", ssh, config))
 }
@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// container engines serving the docker API act runs actions with.
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+// stopTimeout is the time a daemon is given to shut down before it is
+// killed, longer than the 15 seconds dockerd gives containers to stop.
+const stopTimeout = 20 * time.Second
+
+// Engine is a container engine serving a docker compatible API.
+type Engine interface {
+	// Start starts the engine unless it is disabled or already running.
+	Start() error
+	// Wait waits until the engine accepts connections, the engine
+	// exits or the context is done.
+	Wait(ctx context.Context) error
+	// Env returns the environment pointing docker clients at the
+	// engine, such as DOCKER_HOST, or nothing for the default daemon.
+	Env() []string
+	// Log returns the last lines of the output of the engine if it was
+	// started by Start.
+	Log() []string
+	// Stop stops the engine if it was started by Start. It returns an
+	// error if the engine exited before it was stopped.
+	Stop() error
+	// HostPath returns the path the engine binds into containers for
+	// the path in the step container.
+	HostPath(path string) (string, error)
+}
+
+// NewEngine returns the container engine of the daemon configuration,
+// docker by default, the running daemon at the configured host or the
+// daemon of the host in host-socket mode.
+func NewEngine(d Daemon) (Engine, error) {
+	switch d.Mode {
+	case "", ModeDaemon:
+	case ModeHostSocket:
+		return newHostSocket(d)
+	default:
+		return nil, fmt.Errorf("unsupported daemon mode %q", d.Mode)
+	}
+	if d.Host != "" {
+		return newRemote(d)
+	}
+	switch d.Engine {
+	case "", EngineDocker:
+		return &dockerEngine{daemon: d}, nil
+	case EnginePodman:
+		return newPodman(d)
+	}
+	return nil, fmt.Errorf("unsupported container engine %q", d.Engine)
+}
+
+// dockerEngine runs dockerd, rootful or rootless.
+type dockerEngine struct {
+	daemon     Daemon
+	host       string
+	proc       *process
+	configFile string
+}
+
+func (e *dockerEngine) Wait(ctx context.Context) error {
+	return waitForDaemon(ctx, e.Env(), e.proc)
+}
+
+func (e *dockerEngine) Env() []string {
+	return hostEnv(e.host)
+}
+
+func (e *dockerEngine) Log() []string {
+	return e.proc.lines()
+}
+
+func (e *dockerEngine) HostPath(path string) (string, error) {
+	return path, nil
+}
+
+func (e *dockerEngine) Stop() error {
+	err := e.proc.stop()
+	if e.configFile != "" {
+		os.Remove(e.configFile)
+	}
+	return err
+}
+
+// hostEnv returns the environment pointing docker clients at the daemon
+// at host, or nothing for the default daemon.
+func hostEnv(host string) []string {
+	if host == "" {
+		return nil
+	}
+	return []string{"DOCKER_HOST=" + host}
+}
+
+// process is a daemon process started by an engine.
+type process struct {
+	cmd     *exec.Cmd
+	log     *logTail
+	done    chan struct{}
+	err     error
+	stopped bool
+}
+
+// startProcess starts the daemon command, keeping the last lines of its
+// output, writing all of it to the log file of the daemon if set and to
+// stdout in debug mode.
+func startProcess(cmd *exec.Cmd, d Daemon) (*process, error) {
+	p := &process{cmd: cmd, log: newLogTail(logTailLines), done: make(chan struct{})}
+	writers := []io.Writer{p.log}
+	if d.Debug {
+		writers = append(writers, os.Stdout)
+	}
+	var logFile *os.File
+	if d.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(d.LogFile), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(d.LogFile)
+		if err != nil {
+			return nil, err
+		}
+		logFile = f
+		writers = append(writers, f)
+	}
+	out := io.MultiWriter(writers...)
+	cmd.Stdout, cmd.Stderr = out, out
+	trace(cmd)
+	if err := cmd.Start(); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		return nil, err
+	}
+	go func() {
+		p.err = cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// lines returns the last lines of the process output.
+func (p *process) lines() []string {
+	if p == nil {
+		return nil
+	}
+	return p.log.Lines()
+}
+
+// exited returns true and the exit error if the process has exited.
+func (p *process) exited() (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+	select {
+	case <-p.done:
+		return true, p.err
+	default:
+		return false, nil
+	}
+}
+
+// withLog returns the error with the last lines of the process output.
+func (p *process) withLog(err error) error {
+	lines := p.lines()
+	if len(lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%v, last lines of the daemon output:\n%s", err, strings.Join(lines, "\n"))
+}
+
+// stop terminates the process gracefully and waits for it to exit,
+// killing it if it does not exit within the stop timeout.
+func (p *process) stop() error {
+	if p == nil || p.stopped {
+		return nil
+	}
+	p.stopped = true
+	if exited, err := p.exited(); exited {
+		return p.withLog(fmt.Errorf("daemon exited unexpectedly: %v", err))
+	}
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+		return fmt.Errorf("%s did not stop within %s and was killed", p.cmd.Path, stopTimeout)
+	}
+}
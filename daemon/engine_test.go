@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEngine(t *testing.T) {
+	engine, err := NewEngine(Daemon{})
+	require.NoError(t, err)
+	assert.IsType(t, &dockerEngine{}, engine)
+
+	engine, err = NewEngine(Daemon{Engine: EnginePodman})
+	require.NoError(t, err)
+	assert.IsType(t, &podmanEngine{}, engine)
+
+	_, err = NewEngine(Daemon{Engine: "containerd"})
+	assert.Error(t, err)
+}
+
+func TestProcessStop(t *testing.T) {
+	proc, err := startProcess(exec.Command("sleep", "60"), Daemon{})
+	require.NoError(t, err)
+	exited, _ := proc.exited()
+	assert.False(t, exited)
+
+	require.NoError(t, proc.stop())
+	exited, _ = proc.exited()
+	assert.True(t, exited)
+	assert.NoError(t, proc.stop())
+
+	var none *process
+	assert.NoError(t, none.stop())
+}
+
+func TestStartProcessLog(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "logs", "dockerd.log")
+	proc, err := startProcess(exec.Command("sh", "-c", "echo starting; echo listening >&2"), Daemon{LogFile: logFile})
+	require.NoError(t, err)
+	<-proc.done
+
+	assert.Equal(t, []string{"starting", "listening"}, proc.lines())
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "starting\nlistening\n", string(data))
+}
+
+func TestCommandPodman(t *testing.T) {
+	cmd := commandPodman(Daemon{StoragePath: rootfulStoragePath, StorageDriver: "vfs"}, "unix:///run/podman/podman.sock")
+	assert.Equal(t, []string{podmanExe, "--storage-driver", "vfs", "system", "service", "--time=0", "unix:///run/podman/podman.sock"}, cmd.Args)
+
+	cmd = commandPodman(Daemon{StoragePath: "/var/lib/containers", Debug: true}, "unix:///run/podman/podman.sock")
+	assert.Equal(t, []string{podmanExe, "--root", "/var/lib/containers", "--log-level=debug", "system", "service", "--time=0", "unix:///run/podman/podman.sock"}, cmd.Args)
+
+	assert.Equal(t, []string{"dns", "mirror", "mtu"}, podmanIgnored(Daemon{Mirror: "https://mirror.gcr.io", MTU: "1400", DNS: []string{"1.1.1.1"}, StorageDriver: "vfs"}))
+}
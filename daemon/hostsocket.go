@@ -0,0 +1,201 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/drone-plugins/drone-github-actions/pkg/archive"
+)
+
+// modes of providing the docker daemon.
+const (
+	ModeDaemon     = "daemon"      // start a daemon in the step container
+	ModeHostSocket = "host-socket" // use the daemon of the host through its mounted socket
+)
+
+// hostSocketPath is the path the docker socket of the host is mounted at.
+const hostSocketPath = "/var/run/docker.sock"
+
+// containerID matches the id of a docker container in the paths of
+// /proc/self/mountinfo and /proc/self/cgroup.
+var containerID = regexp.MustCompile(`(?:/docker/|/docker-|/containers/)([0-9a-f]{64})`)
+
+// mount is a bind mount or volume of the step container.
+type mount struct {
+	Source      string // path on the host
+	Destination string // path in the container
+}
+
+// hostSocketEngine is the docker daemon of the host, whose socket is
+// mounted into the step container. Containers started by act are
+// siblings of the step container, so their bind mounts are resolved on
+// the host.
+type hostSocketEngine struct {
+	daemon Daemon
+	socket string
+
+	container string  // id of the step container, empty outside of containers
+	mounts    []mount // bind mounts of the step container
+}
+
+func newHostSocket(d Daemon) (Engine, error) {
+	switch {
+	case d.Host != "":
+		return nil, errors.New("daemon.host cannot be used with the host-socket daemon mode")
+	case d.Rootless:
+		return nil, errors.New("rootless docker cannot be used with the host-socket daemon mode")
+	case d.Engine != "" && d.Engine != EngineDocker:
+		return nil, fmt.Errorf("container engine %s cannot be used with the host-socket daemon mode", d.Engine)
+	}
+	return &hostSocketEngine{daemon: d, socket: hostSocketPath}, nil
+}
+
+// Start checks the docker socket of the host is mounted. No daemon is
+// started.
+func (e *hostSocketEngine) Start() error {
+	info, err := os.Stat(e.socket)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("docker socket %s is not mounted, mount the socket of the host into the step to use the host-socket daemon mode", e.socket)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket, mount the docker socket of the host into the step to use the host-socket daemon mode", e.socket)
+	}
+	fmt.Printf("Using docker daemon of the host at %s\n", e.socket)
+	return nil
+}
+
+// Wait waits until the daemon answers and inspects the mounts of the
+// step container, which are needed to translate paths.
+func (e *hostSocketEngine) Wait(ctx context.Context) error {
+	if err := waitForDaemon(ctx, e.Env(), nil); err != nil {
+		return err
+	}
+	e.container = currentContainer()
+	if e.container == "" {
+		fmt.Println("Not running in a container, paths are used as is")
+		return nil
+	}
+	mounts, err := inspectMounts(ctx, e.Env(), e.container)
+	if err != nil {
+		return fmt.Errorf("failed to inspect step container %s: %v", e.container, err)
+	}
+	if _, err := hostPath(mounts, e.socket); err != nil {
+		return fmt.Errorf("docker socket %s is not mounted from the host: %v", e.socket, err)
+	}
+	e.mounts = mounts
+	return nil
+}
+
+func (e *hostSocketEngine) Env() []string {
+	return hostEnv("unix://" + e.socket)
+}
+
+// Log returns nothing, the output of the daemon is not available.
+func (e *hostSocketEngine) Log() []string {
+	return nil
+}
+
+// Stop does nothing, the daemon of the host keeps running.
+func (e *hostSocketEngine) Stop() error {
+	return nil
+}
+
+// HostPath returns the path on the host of the path in the step
+// container, which must be below a bind mount of the container.
+func (e *hostSocketEngine) HostPath(path string) (string, error) {
+	if e.container == "" {
+		return path, nil
+	}
+	return hostPath(e.mounts, path)
+}
+
+// hostPath translates the path using the mount with the longest
+// destination containing it.
+func hostPath(mounts []mount, path string) (string, error) {
+	path = filepath.Clean(path)
+	var match *mount
+	for i, m := range mounts {
+		if archive.Within(m.Destination, path) && (match == nil || len(m.Destination) > len(match.Destination)) {
+			match = &mounts[i]
+		}
+	}
+	if match == nil {
+		return "", fmt.Errorf("%s is not mounted from the host, containers started on the host cannot bind it", path)
+	}
+	rel, _ := filepath.Rel(match.Destination, path)
+	return filepath.Join(match.Source, rel), nil
+}
+
+// currentContainer returns the id of the docker container the plugin
+// runs in, or an empty string if it cannot be determined.
+func currentContainer() string {
+	for _, name := range []string{"/proc/self/mountinfo", "/proc/self/cgroup"} {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		id := findContainerID(f)
+		f.Close()
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// findContainerID returns the first container id found in the lines of
+// r.
+func findContainerID(r io.Reader) string {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if m := containerID.FindStringSubmatch(s.Text()); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// inspectMounts returns the bind mounts and volumes of the container.
+func inspectMounts(ctx context.Context, env []string, container string) ([]mount, error) {
+	cmd := exec.CommandContext(ctx, dockerExe, "inspect", "--type", "container", "--format", "{{json .Mounts}}", container)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return parseMounts(out)
+}
+
+// parseMounts parses the mounts of docker inspect.
+func parseMounts(data []byte) ([]mount, error) {
+	var raw []struct {
+		Type        string
+		Source      string
+		Destination string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid container mounts: %v", err)
+	}
+	var mounts []mount
+	for _, m := range raw {
+		if (m.Type == "bind" || m.Type == "volume") && m.Source != "" && m.Destination != "" {
+			mounts = append(mounts, mount{Source: m.Source, Destination: m.Destination})
+		}
+	}
+	return mounts, nil
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHostSocket(t *testing.T) {
+	engine, err := NewEngine(Daemon{Mode: ModeHostSocket})
+	require.NoError(t, err)
+	assert.IsType(t, &hostSocketEngine{}, engine)
+	assert.Equal(t, []string{"DOCKER_HOST=unix:///var/run/docker.sock"}, engine.Env())
+
+	for _, d := range []Daemon{
+		{Mode: ModeHostSocket, Host: "tcp://docker:2376"},
+		{Mode: ModeHostSocket, Rootless: true},
+		{Mode: ModeHostSocket, Engine: EnginePodman},
+		{Mode: "sidecar"},
+	} {
+		_, err := NewEngine(d)
+		assert.Error(t, err, d)
+	}
+}
+
+func TestHostSocketStart(t *testing.T) {
+	e := &hostSocketEngine{socket: filepath.Join(t.TempDir(), "docker.sock")}
+	err := e.Start()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not mounted")
+
+	require.NoError(t, os.WriteFile(e.socket, nil, 0600))
+	err = e.Start()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a socket")
+}
+
+func TestHostPath(t *testing.T) {
+	mounts := []mount{
+		{Source: "/var/lib/drone/builds/42", Destination: "/drone"},
+		{Source: "/tmp/output-42", Destination: "/drone/output"},
+		{Source: "/var/run/docker.sock", Destination: "/var/run/docker.sock"},
+	}
+	tests := map[string]string{
+		"/drone":                 "/var/lib/drone/builds/42",
+		"/drone/src":             "/var/lib/drone/builds/42/src",
+		"/drone/output":          "/tmp/output-42",
+		"/drone/output/vars.env": "/tmp/output-42/vars.env",
+		"/drone/src/../output/":  "/tmp/output-42",
+		"/var/run/docker.sock":   "/var/run/docker.sock",
+	}
+	for path, want := range tests {
+		got, err := hostPath(mounts, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	_, err := hostPath(mounts, "/dronesrc")
+	assert.Error(t, err)
+	_, err = hostPath(mounts, "/tmp")
+	assert.Error(t, err)
+
+	e := &hostSocketEngine{mounts: mounts}
+	got, err := e.HostPath("/tmp")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp", got, "paths are used as is outside of containers")
+}
+
+func TestFindContainerID(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	mountinfo := `1210 1195 0:52 / / rw,relatime master:322 - overlay overlay rw
+1220 1210 259:1 /var/lib/docker/containers/` + id + `/hostname /etc/hostname rw,relatime - ext4 /dev/nvme0n1p1 rw`
+	assert.Equal(t, id, findContainerID(strings.NewReader(mountinfo)))
+
+	cgroup := "12:pids:/docker/" + id + "\n"
+	assert.Equal(t, id, findContainerID(strings.NewReader(cgroup)))
+
+	assert.Empty(t, findContainerID(strings.NewReader("0::/init.scope\n")))
+}
+
+func TestParseMounts(t *testing.T) {
+	data := `[
+		{"Type":"bind","Source":"/var/run/docker.sock","Destination":"/var/run/docker.sock","Mode":"","RW":true},
+		{"Type":"volume","Name":"drone","Source":"/var/lib/docker/volumes/drone/_data","Destination":"/drone","Driver":"local"},
+		{"Type":"tmpfs","Source":"","Destination":"/run"}
+	]`
+	mounts, err := parseMounts([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, []mount{
+		{Source: "/var/run/docker.sock", Destination: "/var/run/docker.sock"},
+		{Source: "/var/lib/docker/volumes/drone/_data", Destination: "/drone"},
+	}, mounts)
+
+	_, err = parseMounts([]byte("null\n"))
+	assert.NoError(t, err)
+	_, err = parseMounts([]byte("Error"))
+	assert.Error(t, err)
+}
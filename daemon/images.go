@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pullConcurrency is the number of images pulled in parallel.
+const pullConcurrency = 4
+
+// Images configures the images kept in the daemon between builds.
+type Images struct {
+	Archive string   // Image archive in the workspace loaded before the step
+	Save    bool     // Save the images of the daemon to the archive after the step
+	Pull    []string // Images pulled in parallel while the action is cloned
+}
+
+// LoadImages loads the images of the archive written by docker save into
+// the daemon and returns the tagged images loaded. A missing archive,
+// such as on the first build, is skipped.
+func LoadImages(ctx context.Context, archive string) ([]string, error) {
+	if _, err := os.Stat(archive); os.IsNotExist(err) {
+		fmt.Printf("Image archive %s does not exist, no images loaded\n", archive)
+		return nil, nil
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, dockerExe, "load", "--input", archive)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+	cmd.Stderr = os.Stderr
+	trace(cmd)
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return loadedImages(out.Bytes()), nil
+}
+
+// loadedImages returns the tagged images in the output of docker load.
+func loadedImages(out []byte) []string {
+	var images []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if image, ok := strings.CutPrefix(strings.TrimSpace(s.Text()), "Loaded image: "); ok {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// PullImages pulls the images in parallel and returns an error listing
+// the images which could not be pulled.
+func PullImages(ctx context.Context, images []string) error {
+	return pullAll(ctx, images, pullConcurrency, pullImage)
+}
+
+// pullAll pulls the images with up to concurrency pulls running in
+// parallel.
+func pullAll(ctx context.Context, images []string, concurrency int, pull func(context.Context, string) error) error {
+	var (
+		mu       sync.Mutex
+		failures []string
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, concurrency)
+	)
+	for _, image := range images {
+		wg.Add(1)
+		go func(image string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := pull(ctx, image); err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %v", image, err))
+				mu.Unlock()
+			}
+		}(image)
+	}
+	wg.Wait()
+
+	if len(failures) != 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("failed to pull %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+func pullImage(ctx context.Context, image string) error {
+	cmd := exec.CommandContext(ctx, dockerExe, "pull", "--quiet", image)
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	trace(cmd)
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// SaveImages saves the images present in the daemon to the archive,
+// which is replaced once the new archive is complete. Other images of
+// the daemon, which may be shared with the host, are not saved.
+func SaveImages(ctx context.Context, archive string, images []string) error {
+	out, err := exec.CommandContext(ctx, dockerExe, "image", "ls", "--format", "{{.Repository}}:{{.Tag}}\t{{.Repository}}@{{.Digest}}").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list images: %v", err)
+	}
+	present := map[string]bool{}
+	for _, name := range strings.Fields(string(out)) {
+		if !strings.Contains(name, "<none>") {
+			present[normalizeImage(name)] = true
+		}
+	}
+	images = selectImages(present, images)
+	if len(images) == 0 {
+		fmt.Println("No images to save")
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+	tmp := archive + ".tmp"
+	defer os.Remove(tmp)
+	cmd := exec.CommandContext(ctx, dockerExe, append([]string{"save", "--output", tmp}, images...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	trace(cmd)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, archive)
+}
+
+// selectImages returns the images which are present, once each.
+func selectImages(present map[string]bool, images []string) []string {
+	var selected []string
+	seen := map[string]bool{}
+	for _, image := range images {
+		name := normalizeImage(image)
+		if image != "" && present[name] && !seen[name] {
+			seen[name] = true
+			selected = append(selected, image)
+		}
+	}
+	return selected
+}
+
+// normalizeImage returns the image as listed by docker: without the
+// registry and namespace of official Docker Hub images and with the
+// latest tag if it has neither tag nor digest.
+func normalizeImage(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	if !strings.Contains(image, "@") && strings.LastIndex(image, ":") <= strings.LastIndex(image, "/") {
+		image += ":latest"
+	}
+	return image
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullAll(t *testing.T) {
+	var running, peak atomic.Int32
+	pull := func(ctx context.Context, image string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			if p := peak.Load(); n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if image == "private/image" || image == "missing:tag" {
+			return errors.New("denied")
+		}
+		return nil
+	}
+
+	images := []string{"node:20", "alpine:3", "missing:tag", "golang:1.22", "private/image", "busybox"}
+	err := pullAll(context.Background(), images, 2, pull)
+	assert.EqualError(t, err, "failed to pull missing:tag: denied, private/image: denied")
+	assert.Equal(t, int32(2), peak.Load())
+
+	assert.NoError(t, pullAll(context.Background(), nil, 2, pull))
+}
+
+func TestLoadedImages(t *testing.T) {
+	out := []byte("Loaded image: node:20-bookworm-slim\nLoaded image ID: sha256:0123\nLoaded image: ghcr.io/org/tool:v1\n")
+	assert.Equal(t, []string{"node:20-bookworm-slim", "ghcr.io/org/tool:v1"}, loadedImages(out))
+}
+
+func TestSelectImages(t *testing.T) {
+	present := map[string]bool{
+		"node:20-bookworm-slim":          true,
+		"alpine:latest":                  true,
+		"ghcr.io/org/tool:v1":            true,
+		"localhost:5000/app@sha256:abcd": true,
+	}
+	images := []string{
+		"docker.io/library/alpine",
+		"alpine:latest",
+		"node:20-bookworm-slim",
+		"localhost:5000/app@sha256:abcd",
+		"localhost:5000/app",
+		"ghcr.io/org/other:v1",
+		"",
+	}
+	assert.Equal(t, []string{
+		"docker.io/library/alpine",
+		"node:20-bookworm-slim",
+		"localhost:5000/app@sha256:abcd",
+	}, selectImages(present, images))
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"bytes"
+	"sync"
+)
+
+// logTailLines is the number of lines of the daemon log shown when the
+// daemon fails.
+const logTailLines = 30
+
+// maxLineLength is the length after which long lines are split.
+const maxLineLength = 4096
+
+// logTail keeps the last lines written to it.
+type logTail struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+func newLogTail(n int) *logTail {
+	return &logTail{lines: make([]string, n)}
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		switch {
+		case i >= 0:
+			t.add(string(bytes.TrimSuffix(t.partial[:i], []byte("\r"))))
+			t.partial = t.partial[i+1:]
+		case len(t.partial) >= maxLineLength:
+			t.add(string(t.partial[:maxLineLength]))
+			t.partial = t.partial[maxLineLength:]
+		default:
+			// copy the incomplete line so the consumed output can be freed
+			t.partial = append([]byte(nil), t.partial...)
+			return len(p), nil
+		}
+	}
+}
+
+func (t *logTail) add(line string) {
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	t.full = t.full || t.next == 0
+}
+
+// Lines returns the last lines, including an incomplete last line.
+func (t *logTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	if t.full {
+		lines = append(lines, t.lines[t.next:]...)
+	}
+	lines = append(lines, t.lines[:t.next]...)
+	if len(t.partial) != 0 {
+		lines = append(lines, string(t.partial))
+	}
+	return lines
+}
@@ -0,0 +1,119 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const podmanExe = "podman"
+
+// podmanEngine runs the docker compatible API service of podman, as the
+// current user, rootful or rootless.
+type podmanEngine struct {
+	daemon Daemon
+	host   string
+	proc   *process
+}
+
+func newPodman(d Daemon) (Engine, error) {
+	socket := "/run/podman/podman.sock"
+	if os.Getuid() != 0 {
+		runtimeDir, err := rootlessRuntimeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create podman runtime directory: %v", err)
+		}
+		socket = filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	return &podmanEngine{daemon: d, host: "unix://" + socket}, nil
+}
+
+func (e *podmanEngine) Start() error {
+	if e.daemon.Disabled || reachable(hostEnv(e.host)) {
+		fmt.Printf("Using podman service at %s\n", e.host)
+		return nil
+	}
+	if _, err := exec.LookPath(podmanExe); err != nil {
+		return fmt.Errorf("podman not found, install podman to use the podman engine")
+	}
+	if err := os.MkdirAll(filepath.Dir(strings.TrimPrefix(e.host, "unix://")), 0700); err != nil {
+		return err
+	}
+	if ignored := podmanIgnored(e.daemon); len(ignored) != 0 {
+		fmt.Printf("Podman does not support the daemon settings %s, they are ignored\n", strings.Join(ignored, ", "))
+	}
+
+	proc, err := startProcess(commandPodman(e.daemon, e.host), e.daemon)
+	if err != nil {
+		return fmt.Errorf("failed to start podman service: %v", err)
+	}
+	e.proc = proc
+	return nil
+}
+
+func (e *podmanEngine) Wait(ctx context.Context) error {
+	return waitForDaemon(ctx, e.Env(), e.proc)
+}
+
+func (e *podmanEngine) Env() []string {
+	return hostEnv(e.host)
+}
+
+func (e *podmanEngine) Log() []string {
+	return e.proc.lines()
+}
+
+func (e *podmanEngine) HostPath(path string) (string, error) {
+	return path, nil
+}
+
+func (e *podmanEngine) Stop() error {
+	return e.proc.stop()
+}
+
+// helper function to create the podman service command listening on
+// the socket at host.
+func commandPodman(daemon Daemon, host string) *exec.Cmd {
+	var args []string
+	if daemon.StoragePath != "" && daemon.StoragePath != rootfulStoragePath {
+		args = append(args, "--root", daemon.StoragePath)
+	}
+	if daemon.StorageDriver != "" {
+		args = append(args, "--storage-driver", daemon.StorageDriver)
+	}
+	if daemon.Debug {
+		args = append(args, "--log-level=debug")
+	}
+	args = append(args, "system", "service", "--time=0", host)
+	return exec.Command(podmanExe, args...)
+}
+
+// podmanIgnored returns the names of the daemon settings which only
+// apply to dockerd.
+func podmanIgnored(daemon Daemon) []string {
+	var names []string
+	for name, set := range map[string]bool{
+		"bip":          daemon.Bip != "",
+		"config":       daemon.DaemonJSON != "",
+		"dns":          len(daemon.DNS) != 0,
+		"dns-search":   len(daemon.DNSSearch) != 0,
+		"experimental": daemon.Experimental,
+		"insecure":     daemon.Insecure,
+		"ipv6":         daemon.IPv6,
+		"mirror":       daemon.Mirror != "",
+		"mtu":          daemon.MTU != "",
+	} {
+		if set {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// probeInterval is the interval between probes of a starting daemon.
+	probeInterval = 500 * time.Millisecond
+
+	// probeTimeout is the time a single probe may take.
+	probeTimeout = 5 * time.Second
+)
+
+// newProbe returns a function requesting the _ping endpoint of the
+// engine API the environment points to, or of the default daemon if env
+// is empty. Daemons reached over ssh or named pipes are probed with the
+// docker cli instead.
+func newProbe(env []string) func(context.Context) error {
+	host := envValue(env, "DOCKER_HOST")
+	if host == "" {
+		host = defaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return func(context.Context) error {
+			return fmt.Errorf("invalid docker host %q: %v", host, err)
+		}
+	}
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	endpoint := "http://docker/_ping"
+	switch u.Scheme {
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", u.Path)
+		}
+	case "tcp":
+		cfg, err := tlsConfig(env)
+		if err != nil {
+			return func(context.Context) error { return err }
+		}
+		endpoint = "http://" + u.Host + "/_ping"
+		if cfg != nil {
+			transport.TLSClientConfig = cfg
+			endpoint = "https://" + u.Host + "/_ping"
+		}
+	default:
+		return func(ctx context.Context) error {
+			return commandInfo(ctx, env).Run()
+		}
+	}
+
+	client := &http.Client{Transport: transport}
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 512))
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s %s: %s", req.Method, host+"/_ping", res.Status)
+		}
+		return nil
+	}
+}
+
+// reachable returns true if the daemon the environment points to is
+// ready to accept connections.
+func reachable(env []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	return newProbe(env)(ctx) == nil
+}
+
+// tlsConfig returns the TLS configuration the docker cli uses with the
+// environment, or nil if it does not use TLS.
+func tlsConfig(env []string) (*tls.Config, error) {
+	certPath := envValue(env, "DOCKER_CERT_PATH")
+	verify := envValue(env, "DOCKER_TLS_VERIFY") != ""
+	if certPath == "" && !verify && envValue(env, "DOCKER_TLS") == "" {
+		return nil, nil
+	}
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: !verify}
+	ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+	switch {
+	case err == nil:
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA certificate in %s", certPath)
+		}
+	case verify:
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	switch {
+	case err == nil:
+		cfg.Certificates = []tls.Certificate{cert}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to load client certificate: %v", err)
+	}
+	return cfg, nil
+}
+
+// envValue returns the value of the variable in the environment, which
+// overrides the environment of the process.
+func envValue(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,96 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeUnix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	env := []string{"DOCKER_HOST=unix://" + socket}
+	ctx := context.Background()
+	assert.Error(t, newProbe(env)(ctx))
+
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(pingHandler())
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+	assert.NoError(t, newProbe(env)(ctx))
+	assert.True(t, reachable(env))
+}
+
+func TestProbeTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(pingHandler())
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+	ctx := context.Background()
+
+	certPath := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(filepath.Join(certPath, "ca.pem"), ca, 0644))
+
+	assert.Error(t, newProbe([]string{"DOCKER_HOST=" + host})(ctx), "plain http to a TLS daemon")
+	assert.NoError(t, newProbe([]string{"DOCKER_HOST=" + host, "DOCKER_CERT_PATH=" + certPath, "DOCKER_TLS_VERIFY=1"})(ctx))
+	assert.Error(t, newProbe([]string{"DOCKER_HOST=" + host, "DOCKER_CERT_PATH=" + t.TempDir(), "DOCKER_TLS_VERIFY=1"})(ctx))
+}
+
+func TestWaitForDaemonExited(t *testing.T) {
+	env := []string{"DOCKER_HOST=unix://" + filepath.Join(t.TempDir(), "docker.sock")}
+	proc, err := startProcess(exec.Command("sh", "-c", "echo starting; echo failed to create network >&2; exit 1"), Daemon{})
+	require.NoError(t, err)
+
+	err = waitForDaemon(context.Background(), env, proc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exited before it was ready: exit status 1")
+	assert.Contains(t, err.Error(), "starting\nfailed to create network")
+
+	err = proc.stop()
+	assert.ErrorContains(t, err, "exited unexpectedly")
+}
+
+func TestWaitForDaemonTimeout(t *testing.T) {
+	env := []string{"DOCKER_HOST=unix://" + filepath.Join(t.TempDir(), "docker.sock")}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorContains(t, waitForDaemon(ctx, env, nil), "not ready in time")
+}
+
+func TestLogTail(t *testing.T) {
+	tail := newLogTail(3)
+	tail.Write([]byte("one\ntwo\r\nthr"))
+	assert.Equal(t, []string{"one", "two", "thr"}, tail.Lines())
+	tail.Write([]byte("ee\nfour\nfive\nsix"))
+	assert.Equal(t, []string{"three", "four", "five", "six"}, tail.Lines())
+
+	tail = newLogTail(2)
+	long := make([]byte, maxLineLength+1)
+	tail.Write(long)
+	assert.Len(t, tail.Lines(), 2)
+	assert.Len(t, tail.Lines()[0], maxLineLength)
+}
+
+func pingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
+}
@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// remoteEngine is a running docker daemon the plugin does not start,
+// reached over TCP, optionally with TLS, over SSH or over a socket.
+type remoteEngine struct {
+	daemon Daemon
+	url    *url.URL
+	dir    string // temporary directory of certificates and keys
+	path   string // PATH with the ssh wrapper, if configured
+}
+
+func newRemote(d Daemon) (Engine, error) {
+	u, err := url.Parse(d.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid daemon host %q: %v", d.Host, err)
+	}
+	switch u.Scheme {
+	case "tcp", "ssh", "unix", "npipe":
+	default:
+		return nil, fmt.Errorf("unsupported daemon host %q, use a tcp://, ssh:// or unix:// address", d.Host)
+	}
+
+	tls := d.TLSVerify || d.TLSCACert != "" || d.TLSCert != "" || d.TLSKey != ""
+	switch {
+	case tls && u.Scheme != "tcp":
+		return nil, fmt.Errorf("daemon TLS settings require a tcp:// daemon host, not %s", d.Host)
+	case d.TLSVerify && d.TLSCACert == "":
+		return nil, errors.New("daemon TLS verification requires the CA certificate of the daemon")
+	case (d.TLSCert == "") != (d.TLSKey == ""):
+		return nil, errors.New("daemon TLS client certificate and key must be set together")
+	case (d.SSHKey != "" || d.SSHKnownHosts != "") && u.Scheme != "ssh":
+		return nil, fmt.Errorf("daemon SSH settings require an ssh:// daemon host, not %s", d.Host)
+	}
+	return &remoteEngine{daemon: d, url: u}, nil
+}
+
+// Start writes the certificates and keys used to connect to the daemon.
+func (e *remoteEngine) Start() error {
+	fmt.Printf("Using docker daemon at %s\n", e.daemon.Host)
+	if e.url.Scheme == "ssh" {
+		if _, err := exec.LookPath("ssh"); err != nil {
+			return errors.New("ssh not found, install an ssh client to use ssh:// daemon hosts")
+		}
+		if e.daemon.SSHKey != "" || e.daemon.SSHKnownHosts != "" {
+			return e.configureSSH()
+		}
+		return nil
+	}
+
+	for name, value := range map[string]string{
+		"ca.pem":   e.daemon.TLSCACert,
+		"cert.pem": e.daemon.TLSCert,
+		"key.pem":  e.daemon.TLSKey,
+	} {
+		if value == "" {
+			continue
+		}
+		if err := e.writePEM(name, value); err != nil {
+			return fmt.Errorf("failed to write daemon TLS %s: %v", strings.TrimSuffix(name, ".pem"), err)
+		}
+	}
+	return nil
+}
+
+func (e *remoteEngine) Wait(ctx context.Context) error {
+	return waitForDaemon(ctx, e.Env(), nil)
+}
+
+func (e *remoteEngine) Env() []string {
+	env := hostEnv(e.daemon.Host)
+	if e.dir != "" && e.url.Scheme == "tcp" {
+		env = append(env, "DOCKER_CERT_PATH="+e.dir, "DOCKER_TLS=1")
+	}
+	if e.daemon.TLSVerify {
+		env = append(env, "DOCKER_TLS_VERIFY=1")
+	}
+	if e.path != "" {
+		env = append(env, "PATH="+e.path)
+	}
+	return env
+}
+
+// Log returns nothing, the output of the daemon is not available.
+func (e *remoteEngine) Log() []string {
+	return nil
+}
+
+// HostPath returns the path unchanged, paths of the step container are
+// bound as if the daemon ran on the same host.
+func (e *remoteEngine) HostPath(path string) (string, error) {
+	return path, nil
+}
+
+// Stop removes the certificates, keys and ssh configuration. The
+// daemon keeps running.
+func (e *remoteEngine) Stop() error {
+	if e.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(e.dir)
+}
+
+// configureSSH writes the key and known hosts of the daemon host to a
+// private ssh configuration, and an ssh wrapper using it which is put
+// first on the PATH, since the ssh client started by docker cannot be
+// given options directly. The configuration of the user and the system
+// still applies to the options not set.
+func (e *remoteEngine) configureSSH() error {
+	ssh, err := exec.LookPath("ssh")
+	if err != nil {
+		return err
+	}
+	config := fmt.Sprintf("Host %s\n", e.url.Hostname())
+	if e.daemon.SSHKey != "" {
+		if err := e.writePEM("id_key", e.daemon.SSHKey); err != nil {
+			return fmt.Errorf("failed to write daemon SSH key: %v", err)
+		}
+		config += fmt.Sprintf("  IdentityFile %s\n  IdentitiesOnly yes\n", filepath.Join(e.dir, "id_key"))
+	}
+	if e.daemon.SSHKnownHosts != "" {
+		if err := e.write("known_hosts", []byte(e.daemon.SSHKnownHosts)); err != nil {
+			return fmt.Errorf("failed to write daemon SSH known hosts: %v", err)
+		}
+		config += fmt.Sprintf("  UserKnownHostsFile %s\n  StrictHostKeyChecking yes\n", filepath.Join(e.dir, "known_hosts"))
+	}
+	// the first value set for an option wins, so the configuration of
+	// the user and the system is included last
+	config += "\nMatch all\n  Include ~/.ssh/config " + systemSSHConfig + "\n"
+	if err := e.write("ssh_config", []byte(config)); err != nil {
+		return fmt.Errorf("failed to write daemon SSH configuration: %v", err)
+	}
+
+	name, script := sshWrapper(ssh, filepath.Join(e.dir, "ssh_config"))
+	if err := e.write(name, script); err != nil {
+		return fmt.Errorf("failed to write ssh wrapper: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(e.dir, name), 0700); err != nil {
+		return err
+	}
+	e.path = e.dir + string(os.PathListSeparator) + os.Getenv("PATH")
+	return nil
+}
+
+// writePEM writes the PEM encoded setting to a file in the temporary
+// directory. The setting is either the PEM contents, e.g. from a
+// secret, or the path of a file to copy.
+func (e *remoteEngine) writePEM(name, value string) error {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN ") {
+		var err error
+		if data, err = ioutil.ReadFile(value); err != nil {
+			return err
+		}
+	}
+	return e.write(name, data)
+}
+
+// write writes the file to the temporary directory, ending it with a
+// newline as ssh requires for keys.
+func (e *remoteEngine) write(name string, data []byte) error {
+	if len(data) != 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if e.dir == "" {
+		dir, err := ioutil.TempDir("", "docker-remote-")
+		if err != nil {
+			return err
+		}
+		e.dir = dir
+	}
+	return ioutil.WriteFile(filepath.Join(e.dir, name), data, 0600)
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPEM = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"
+
+func TestNewRemote(t *testing.T) {
+	for name, test := range map[string]struct {
+		daemon Daemon
+		valid  bool
+	}{
+		"tcp":                 {daemon: Daemon{Host: "tcp://docker:2375"}, valid: true},
+		"tls":                 {daemon: Daemon{Host: "tcp://docker:2376", TLSVerify: true, TLSCACert: testPEM}, valid: true},
+		"ssh":                 {daemon: Daemon{Host: "ssh://build@docker", SSHKey: testPEM}, valid: true},
+		"unix":                {daemon: Daemon{Host: "unix:///run/user/1000/docker.sock"}, valid: true},
+		"scheme":              {daemon: Daemon{Host: "https://docker:2376"}},
+		"tls-without-ca":      {daemon: Daemon{Host: "tcp://docker:2376", TLSVerify: true}},
+		"tls-without-key":     {daemon: Daemon{Host: "tcp://docker:2376", TLSCert: testPEM}},
+		"tls-over-ssh":        {daemon: Daemon{Host: "ssh://docker", TLSCACert: testPEM}},
+		"ssh-key-without-ssh": {daemon: Daemon{Host: "tcp://docker:2375", SSHKey: testPEM}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine, err := NewEngine(test.daemon)
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, &remoteEngine{}, engine)
+		})
+	}
+}
+
+func TestRemoteTLS(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca, []byte(testPEM+"\n"), 0644))
+
+	engine, err := NewEngine(Daemon{Host: "tcp://docker:2376", TLSVerify: true, TLSCACert: ca, TLSCert: testPEM, TLSKey: testPEM})
+	require.NoError(t, err)
+	require.NoError(t, engine.Start())
+
+	dir := engine.(*remoteEngine).dir
+	assert.Equal(t, []string{"DOCKER_HOST=tcp://docker:2376", "DOCKER_CERT_PATH=" + dir, "DOCKER_TLS=1", "DOCKER_TLS_VERIFY=1"}, engine.Env())
+	for _, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, testPEM+"\n", string(data))
+	}
+
+	require.NoError(t, engine.Stop())
+	assert.NoDirExists(t, dir)
+}
+
+func TestRemoteSSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	config := filepath.Join(home, ".ssh", "config")
+	require.NoError(t, os.MkdirAll(filepath.Dir(config), 0700))
+	require.NoError(t, os.WriteFile(config, []byte("Host *\n  ServerAliveInterval 30\n"), 0600))
+
+	engine, err := NewEngine(Daemon{Host: "ssh://build@docker.example.com:2222", SSHKey: testPEM, SSHKnownHosts: "docker.example.com ssh-ed25519 AAAA"})
+	require.NoError(t, err)
+	require.NoError(t, engine.Start())
+
+	dir := engine.(*remoteEngine).dir
+	path := dir + string(os.PathListSeparator) + os.Getenv("PATH")
+	assert.Equal(t, []string{"DOCKER_HOST=ssh://build@docker.example.com:2222", "PATH=" + path}, engine.Env())
+
+	// the ssh found on the PATH uses the key and known hosts, and the
+	// configuration of the user for the other options
+	t.Setenv("PATH", path)
+	ssh, err := exec.LookPath("ssh")
+	require.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(ssh))
+	out, err := exec.Command("ssh", "-G", "docker.example.com").Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "identityfile "+filepath.Join(dir, "id_key")+"\n")
+	assert.Contains(t, string(out), "userknownhostsfile "+filepath.Join(dir, "known_hosts")+"\n")
+	assert.Contains(t, string(out), "serveraliveinterval 30\n")
+
+	// the configuration of the user is not changed
+	data, err := os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, "Host *\n  ServerAliveInterval 30\n", string(data))
+
+	require.NoError(t, engine.Stop())
+	assert.NoDirExists(t, dir)
+}
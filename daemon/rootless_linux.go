@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const rootlessExe = "dockerd-rootless.sh"
+
+// startRootless uses the docker daemon DOCKER_HOST points to if it is
+// running, or starts a rootless docker daemon as the current user.
+func (e *dockerEngine) startRootless() error {
+	d := e.daemon
+	if d.Disabled || os.Getenv("DOCKER_HOST") != "" && reachable(nil) {
+		fmt.Printf("Using docker daemon at %s\n", os.Getenv("DOCKER_HOST"))
+		return nil
+	}
+
+	runtimeDir, err := rootlessRuntimeDir()
+	if err != nil {
+		return fmt.Errorf("failed to create rootless docker runtime directory: %v", err)
+	}
+	e.host = "unix://" + filepath.Join(runtimeDir, "docker.sock")
+	if reachable(hostEnv(e.host)) {
+		fmt.Printf("Using rootless docker daemon at %s\n", e.host)
+		return nil
+	}
+	if err := checkRootless(); err != nil {
+		return fmt.Errorf("cannot start rootless docker daemon: %v", err)
+	}
+
+	dataRoot := d.StoragePath
+	if dataRoot == rootfulStoragePath {
+		dataRoot = ""
+	}
+	if err := e.writeConfig("dockerd", dataRoot); err != nil {
+		return err
+	}
+	e.proc, err = startProcess(commandRootless(runtimeDir, e.configFile), d)
+	if err != nil {
+		return fmt.Errorf("failed to start rootless docker daemon: %v", err)
+	}
+	return nil
+}
+
+// helper function to create the rootless docker daemon command.
+func commandRootless(runtimeDir, configFile string) *exec.Cmd {
+	cmd := exec.Command(rootlessExe,
+		"--config-file", configFile,
+		"--host=unix://"+filepath.Join(runtimeDir, "docker.sock"),
+	)
+	cmd.Env = append(os.Environ(), "XDG_RUNTIME_DIR="+runtimeDir)
+	return cmd
+}
+
+// checkRootless returns an error describing why a rootless docker daemon
+// cannot be started on this host, if it cannot.
+func checkRootless() error {
+	if os.Getuid() == 0 {
+		return errors.New("the rootless daemon cannot be started as root, run the plugin as a non-root user")
+	}
+	for _, exe := range []string{rootlessExe, "rootlesskit"} {
+		if _, err := exec.LookPath(exe); err != nil {
+			return fmt.Errorf("%s not found, use an image with the docker rootless extras installed", exe)
+		}
+	}
+	for _, exe := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(exe); err != nil {
+			return fmt.Errorf("%s not found, install the uidmap package", exe)
+		}
+	}
+	if reason := userNamespacesDisabled("/proc"); reason != "" {
+		return fmt.Errorf("user namespaces are not available, %s", reason)
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	name := uid
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	for _, file := range []string{"/etc/subuid", "/etc/subgid"} {
+		if !hasSubordinateIDs(file, name, uid) {
+			return fmt.Errorf("no subordinate ids are assigned to user %s in %s, add a range such as %s:100000:65536", name, file, name)
+		}
+	}
+
+	if err := unshareUser(); err != nil {
+		return fmt.Errorf("failed to create a user namespace: %v, the container may need seccomp and apparmor profiles allowing unshare", err)
+	}
+	return nil
+}
+
+// userNamespacesDisabled returns the reason why the kernel settings below
+// the proc file system at proc prevent creating user namespaces as an
+// unprivileged user, or an empty string if they do not.
+func userNamespacesDisabled(proc string) string {
+	sysctl := func(name string) string {
+		v, err := ioutil.ReadFile(filepath.Join(proc, "sys", filepath.FromSlash(name)))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(v))
+	}
+	switch {
+	case sysctl("user/max_user_namespaces") == "0":
+		return "set the user.max_user_namespaces sysctl to a non-zero value"
+	case sysctl("kernel/unprivileged_userns_clone") == "0":
+		return "set the kernel.unprivileged_userns_clone sysctl to 1"
+	case sysctl("kernel/apparmor_restrict_unprivileged_userns") == "1":
+		return "set the kernel.apparmor_restrict_unprivileged_userns sysctl to 0"
+	}
+	return ""
+}
+
+// hasSubordinateIDs returns true if the subordinate id file, such as
+// /etc/subuid, assigns a range to the user with the name or uid.
+func hasSubordinateIDs(file, name, uid string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(strings.TrimSpace(s.Text()), ":")
+		if len(fields) == 3 && (fields[0] == name || fields[0] == uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// unshareUser runs a command in a new user namespace, which fails if
+// seccomp or apparmor deny creating one.
+func unshareUser() error {
+	exe, err := exec.LookPath("true")
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	return cmd.Run()
+}
@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserNamespacesDisabled(t *testing.T) {
+	for name, test := range map[string]struct {
+		sysctls  map[string]string
+		disabled bool
+	}{
+		"default":          {sysctls: map[string]string{"user/max_user_namespaces": "63000\n"}},
+		"max":              {sysctls: map[string]string{"user/max_user_namespaces": "0\n"}, disabled: true},
+		"unprivileged":     {sysctls: map[string]string{"kernel/unprivileged_userns_clone": "0\n"}, disabled: true},
+		"apparmor":         {sysctls: map[string]string{"kernel/apparmor_restrict_unprivileged_userns": "1\n"}, disabled: true},
+		"apparmor-allowed": {sysctls: map[string]string{"kernel/apparmor_restrict_unprivileged_userns": "0\n"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			proc := t.TempDir()
+			for key, value := range test.sysctls {
+				path := filepath.Join(proc, "sys", filepath.FromSlash(key))
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(value), 0644))
+			}
+			assert.Equal(t, test.disabled, userNamespacesDisabled(proc) != "")
+		})
+	}
+}
+
+func TestHasSubordinateIDs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subuid")
+	require.NoError(t, os.WriteFile(file, []byte("root:100000:65536\n1001:165536:65536\n"), 0644))
+
+	assert.True(t, hasSubordinateIDs(file, "root", "0"))
+	assert.True(t, hasSubordinateIDs(file, "1001", "1001"))
+	assert.True(t, hasSubordinateIDs(file, "build", "1001"))
+	assert.False(t, hasSubordinateIDs(file, "build", "1002"))
+	assert.False(t, hasSubordinateIDs(filepath.Join(t.TempDir(), "missing"), "root", "0"))
+}
+
+func TestCommandRootless(t *testing.T) {
+	cmd := commandRootless("/run/user/1000", "/tmp/daemon.json")
+	assert.Equal(t, []string{rootlessExe, "--config-file", "/tmp/daemon.json", "--host=unix:///run/user/1000/docker.sock"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "XDG_RUNTIME_DIR=/run/user/1000")
+}
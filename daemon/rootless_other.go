@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package daemon
+
+import "errors"
+
+func (e *dockerEngine) startRootless() error {
+	return errors.New("rootless docker daemon is only supported on linux")
+}
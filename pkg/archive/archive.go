@@ -0,0 +1,288 @@
+// Package archive extracts tarballs and zip archives without writing
+// outside of the target directory.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxLinks is the number of symlinks followed when resolving a path.
+const maxLinks = 255
+
+// Extractor writes archive entries below Root. Symlinks are created
+// after all other entries, so no entry is written through a symlink of
+// the archive, and each symlink is checked with the symlinks created
+// before it resolved, so no chain of symlinks leads outside of Root.
+type Extractor struct {
+	Root    string      // directory the entries are written to
+	Strip   int         // number of leading path components removed from entry names
+	DirMode os.FileMode // mode of created directories, 0755 if zero
+
+	// LinkRoot returns the directory the symlink at path may point
+	// into, Root if nil.
+	LinkRoot func(path string) string
+
+	pending []link
+}
+
+type link struct {
+	name   string // entry name below Root
+	target string // link target
+}
+
+// ExtractTar extracts the gzip compressed tarball to the directory of
+// the extractor.
+func ExtractTar(r io.Reader, x *Extractor) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if err := x.Tar(hdr, tr); err != nil {
+			return err
+		}
+	}
+	return x.Links()
+}
+
+// ExtractZip extracts the zip archive to the directory of the
+// extractor.
+func ExtractZip(r io.ReaderAt, size int64, x *Extractor) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	for _, zf := range zr.File {
+		if err := x.Zip(zf); err != nil {
+			return err
+		}
+	}
+	return x.Links()
+}
+
+// Tar extracts the tarball entry, whose contents are read from r.
+func (x *Extractor) Tar(hdr *tar.Header, r io.Reader) error {
+	switch hdr.Typeflag {
+	case tar.TypeXGlobalHeader, tar.TypeXHeader:
+		return nil
+	case tar.TypeDir:
+		return x.Dir(hdr.Name)
+	case tar.TypeReg:
+		return x.File(hdr.Name, os.FileMode(hdr.Mode), r)
+	case tar.TypeSymlink:
+		return x.Symlink(hdr.Name, hdr.Linkname)
+	}
+	return fmt.Errorf("archive entry %s has unsupported type %c", hdr.Name, hdr.Typeflag)
+}
+
+// Zip extracts the zip archive entry.
+func (x *Extractor) Zip(zf *zip.File) error {
+	mode := zf.Mode()
+	switch {
+	case mode.IsDir():
+		return x.Dir(zf.Name)
+	case mode&os.ModeSymlink != 0:
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		target, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return x.Symlink(zf.Name, string(target))
+	case mode.IsRegular():
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return x.File(zf.Name, mode, rc)
+	}
+	return fmt.Errorf("archive entry %s has unsupported mode %s", zf.Name, mode)
+}
+
+// Dir creates the directory entry.
+func (x *Extractor) Dir(name string) error {
+	path, err := x.path(name)
+	if err != nil || path == "" {
+		return err
+	}
+	return os.MkdirAll(path, x.dirMode())
+}
+
+// File writes the regular file entry with the contents read from r.
+func (x *Extractor) File(name string, mode os.FileMode, r io.Reader) error {
+	path, err := x.path(name)
+	if err != nil || path == "" {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), x.dirMode()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()&0755|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Symlink records the symlink entry, which is created by Links.
+func (x *Extractor) Symlink(name, target string) error {
+	path, err := x.path(name)
+	if err != nil || path == "" {
+		return err
+	}
+	// reject what can be seen from the entry alone early, links are
+	// checked again once the links before them exist
+	root := x.linkRoot(path)
+	if filepath.IsAbs(target) || !Within(root, filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("archive symlink %s points outside %s", name, root)
+	}
+	rel, _ := filepath.Rel(x.Root, path)
+	x.pending = append(x.pending, link{name: rel, target: target})
+	return nil
+}
+
+// Links creates the symlinks recorded during extraction.
+func (x *Extractor) Links() error {
+	for _, l := range x.pending {
+		root := x.linkRoot(filepath.Join(x.Root, l.name))
+		dir, err := resolve(x.Root, x.Root, filepath.Dir(l.name))
+		if err != nil {
+			return fmt.Errorf("archive symlink %s is placed outside %s", l.name, x.Root)
+		}
+		if _, err := resolve(root, dir, l.target); err != nil {
+			return fmt.Errorf("archive symlink %s points outside %s", l.name, root)
+		}
+		if err := os.MkdirAll(dir, x.dirMode()); err != nil {
+			return err
+		}
+		if err := os.Symlink(l.target, filepath.Join(dir, filepath.Base(l.name))); err != nil {
+			return err
+		}
+	}
+	x.pending = nil
+	return nil
+}
+
+// path returns the path of the entry below Root after stripping the
+// leading components, or an empty path for entries stripped entirely
+// and Root itself.
+func (x *Extractor) path(name string) (string, error) {
+	rel := strings.TrimPrefix(filepath.ToSlash(name), "/")
+	if x.Strip > 0 {
+		parts := strings.SplitN(rel, "/", x.Strip+1)
+		if len(parts) <= x.Strip {
+			return "", nil
+		}
+		rel = parts[x.Strip]
+	}
+	path := filepath.Join(x.Root, filepath.FromSlash(rel))
+	if !Within(x.Root, path) {
+		return "", fmt.Errorf("archive entry %s escapes %s", name, x.Root)
+	}
+	if path == filepath.Clean(x.Root) {
+		return "", nil
+	}
+
+	// entries are never written through symlinks
+	rel, _ = filepath.Rel(filepath.Clean(x.Root), path)
+	dir, err := resolve(x.Root, x.Root, filepath.Dir(rel))
+	if err != nil {
+		return "", fmt.Errorf("archive entry %s escapes %s", name, x.Root)
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+func (x *Extractor) linkRoot(path string) string {
+	if x.LinkRoot != nil {
+		return x.LinkRoot(path)
+	}
+	return x.Root
+}
+
+func (x *Extractor) dirMode() os.FileMode {
+	if x.DirMode == 0 {
+		return 0755
+	}
+	return x.DirMode
+}
+
+// errEscape is returned by resolve for paths leading outside of the
+// bounding directory.
+var errEscape = errors.New("path escapes its root")
+
+// resolve returns the path rel leads to from the directory base,
+// following the symlinks on the way, and errEscape if any step of it
+// leads outside of bound. Components which do not exist are kept.
+func resolve(bound, base, rel string) (string, error) {
+	cur := filepath.Clean(base)
+	if !Within(bound, cur) {
+		return "", errEscape
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for links := 0; len(parts) != 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			next := filepath.Join(cur, part)
+			info, err := os.Lstat(next)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if links++; links > maxLinks {
+					return "", fmt.Errorf("too many symlinks in %s", rel)
+				}
+				target, err := os.Readlink(next)
+				if err != nil {
+					return "", err
+				}
+				if filepath.IsAbs(target) {
+					return "", errEscape
+				}
+				// the target is resolved relative to the directory of
+				// the link, which is cur
+				parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+				continue
+			}
+			cur = next
+		}
+		if !Within(bound, cur) {
+			return "", errEscape
+		}
+	}
+	return cur, nil
+}
+
+// Within returns true if path is root or a descendant of root.
+func Within(root, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractorLinks(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	x := &Extractor{Root: root}
+	require.NoError(t, x.File("dist/index.js", 0644, strings.NewReader("main()")))
+	require.NoError(t, x.Symlink("lib", "dist"))
+	require.NoError(t, x.Symlink("main.js", "lib/index.js"))
+	require.NoError(t, x.Symlink("self", "."))
+	require.NoError(t, x.Links())
+
+	data, err := os.ReadFile(filepath.Join(root, "main.js"))
+	require.NoError(t, err)
+	assert.Equal(t, "main()", string(data))
+	data, err = os.ReadFile(filepath.Join(root, "self", "lib", "index.js"))
+	require.NoError(t, err)
+	assert.Equal(t, "main()", string(data))
+}
+
+func TestExtractorRejectsEscapes(t *testing.T) {
+	tests := map[string]func(x *Extractor) error{
+		"path": func(x *Extractor) error {
+			return x.File("../evil", 0644, strings.NewReader("x"))
+		},
+		"symlink": func(x *Extractor) error {
+			return x.Symlink("link", "../evil")
+		},
+		"absolute-symlink": func(x *Extractor) error {
+			return x.Symlink("link", "/etc/passwd")
+		},
+		"symlink-chain": func(x *Extractor) error {
+			if err := x.Symlink("a", "."); err != nil {
+				return err
+			}
+			return x.Symlink("a/b", "..")
+		},
+		"symlink-through-symlink": func(x *Extractor) error {
+			if err := x.Symlink("a", "."); err != nil {
+				return err
+			}
+			return x.Symlink("c", "a/../evil")
+		},
+		"link-root": func(x *Extractor) error {
+			x.LinkRoot = func(string) string { return filepath.Join(x.Root, "entry") }
+			return x.Symlink("entry/link", "../other")
+		},
+	}
+	for name, extract := range tests {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			x := &Extractor{Root: filepath.Join(parent, "root")}
+			err := extract(x)
+			if err == nil {
+				err = x.Links()
+			}
+			assert.Error(t, err)
+			assert.NoFileExists(t, filepath.Join(parent, "evil"))
+		})
+	}
+}
+
+func TestExtractorStrip(t *testing.T) {
+	root := t.TempDir()
+	x := &Extractor{Root: root, Strip: 1}
+	require.NoError(t, x.Dir("repo-abc123/"))
+	require.NoError(t, x.File("repo-abc123/action.yml", 0644, strings.NewReader("name: test")))
+	require.NoError(t, x.Links())
+	assert.FileExists(t, filepath.Join(root, "action.yml"))
+}
+
+func TestWithin(t *testing.T) {
+	assert.True(t, Within("/drone", "/drone"))
+	assert.True(t, Within("/drone/", "/drone/src"))
+	assert.False(t, Within("/drone", "/dronesrc"))
+	assert.False(t, Within("/drone/src", "/drone"))
+}
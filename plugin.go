@@ -8,9 +8,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/drone-plugins/drone-github-actions/cache"
 	"github.com/drone-plugins/drone-github-actions/cloner"
 	"github.com/drone-plugins/drone-github-actions/daemon"
+	"github.com/drone-plugins/drone-github-actions/policy"
 	"github.com/drone-plugins/drone-github-actions/utils"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -32,56 +35,211 @@ type (
 		Uses         string
 		With         map[string]string
 		Env          map[string]string
-		Image        string
-		EventPayload string // Webhook event payload
+		Image        string            // Runner image, selected from the runtime of the action if empty
+		RunsOn       string            // Label of the runner running the action
+		Platforms    map[string]string // Runner images of runs-on labels
+		Runtimes     map[string]string // Runner images of action runtimes, e.g. node20
+		EventPayload string            // Webhook event payload
 		Actor        string
 		Verbose      bool
 	}
 
 	Plugin struct {
-		Action Action
-		Daemon daemon.Daemon // Docker daemon configuration
+		Action  Action
+		Daemon  daemon.Daemon // Container engine configuration
+		Images  daemon.Images // Images loaded, pulled and saved around the action
+		Policy  string        // Path to the action policy file
+		Offline bool          // Use only the local action cache
+		Strict  bool          // Fail the step if the action cannot be resolved
+
+		CloneMethod  string         // Method used to fetch actions, git, tarball or zipball
+		CloneOptions cloner.Options // Optional features used to fetch actions
+
+		CacheEviction    cache.EvictOptions // Action cache eviction policy
+		CacheFreshness   time.Duration      // Interval after which cached branches and tags are revalidated
+		CacheLockTimeout time.Duration      // Time to wait for other steps adding the action to the cache
+		CacheRemote      cache.Backend      // Remote action cache shared between runners, optional
 	}
 )
 
 // Exec executes the plugin step
 func (p Plugin) Exec() error {
-	if err := daemon.StartDaemon(p.Daemon); err != nil {
-		return err
+	if p.Images.Save && p.Images.Archive == "" {
+		return errors.New("saving images requires an image archive")
 	}
 
 	ctx := context.Background()
-	repoURL, ref, ok := utils.ParseLookup(p.Action.Uses)
-	if !ok {
+	engine, err := daemon.StartDaemon(ctx, p.Daemon)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := engine.Stop(); err != nil {
+			logrus.Warnf("Failed to stop container engine: %v", err)
+		}
+	}()
+	removeAuth, err := daemon.WriteAuthConfig(p.Daemon)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure docker registry credentials")
+	}
+	defer removeAuth()
+
+	// saved are the images kept in the archive, other images of the
+	// daemon may belong to the host
+	var saved []string
+	if p.Images.Archive != "" {
+		if saved, err = daemon.LoadImages(ctx, p.Images.Archive); err != nil {
+			logrus.Warnf("Failed to load images from %s: %v", p.Images.Archive, err)
+		}
+	}
+	// pulled is closed once the pulls are done, so it can be waited on
+	// again after their error is read
+	pulled := make(chan error, 1)
+	if p.Offline && len(p.Images.Pull) != 0 {
+		logrus.Warnf("Skipping pull of images in offline mode")
+		close(pulled)
+	} else {
+		// images are pulled while the action is cloned
+		pullCtx, cancelPull := context.WithCancel(ctx)
+		go func() {
+			defer close(pulled)
+			pulled <- daemon.PullImages(pullCtx, p.Images.Pull)
+		}()
+		// pulls still running when the action fails before act are
+		// cancelled and waited for before the engine is stopped
+		defer func() {
+			cancelPull()
+			<-pulled
+		}()
+	}
+
+	repoURL, ref, err := utils.ParseReference(p.Action.Uses)
+	if err != nil {
+		if p.Strict && !isDockerAction(p.Action.Uses) {
+			return err
+		}
 		logrus.Warnf("Invalid 'uses' format: %s", p.Action.Uses)
+		repoURL, ref, _ = utils.ParseLookup(p.Action.Uses)
 	}
 	logrus.Infof("Parsed 'uses' string. Repo: %s, Ref: %s", repoURL, ref)
 
-	// Clone the GH Action repository using `cloner` with parsed repo and ref
-	clone := cloner.NewCache(cloner.NewDefault())
-	codedir, cloneErr := clone.Clone(ctx, repoURL, ref, "")
-	if cloneErr != nil {
-		logrus.Warnf("Failed to clone GH Action: %v", cloneErr)
+	var pol *policy.Policy
+	if p.Policy != "" {
+		if pol, err = policy.Load(p.Policy); err != nil {
+			return err
+		}
+		in := policy.Input{Repo: repoURL, Ref: ref}
+		if isDockerAction(p.Action.Uses) {
+			in = policy.Input{Image: strings.TrimPrefix(p.Action.Uses, "docker://")}
+		}
+		if err := pol.CheckRef(in); err != nil {
+			return err
+		}
+	}
+
+	var codedir string
+	if isDockerAction(p.Action.Uses) {
+		// docker actions are run by act directly from the image
+		logrus.Infof("Skipping clone of docker action %s", p.Action.Uses)
 	} else {
-		logrus.Infof("Successfully cloned GH Action to %s", codedir)
+		// Clone the GH Action repository using `cloner` with parsed repo and ref
+		base, err := cloner.NewMethod(p.CloneMethod, os.Stdout, p.CloneOptions)
+		if err != nil {
+			return err
+		}
+		clone := cloner.NewCache(base).WithFreshness(p.CacheFreshness).WithRemote(p.CacheRemote)
+		if p.Offline {
+			clone = cloner.NewOfflineCache(base)
+		}
+		clone = clone.WithLockTimeout(p.CacheLockTimeout)
+		// actions cloned outside of the cache are removed once act ran
+		defer clone.Cleanup()
+		var cloneErr error
+		codedir, cloneErr = clone.Clone(ctx, repoURL, ref, "")
+		if cloneErr != nil {
+			if p.Strict || p.Offline {
+				return cloneError(repoURL, ref, cloneErr)
+			}
+			logrus.Warnf("Failed to clone GH Action: %v", cloneErr)
+		} else {
+			logrus.Infof("Successfully cloned GH Action to %s", codedir)
+		}
+	}
+
+	if !p.Offline {
+		recoverCache()
+	}
+	if p.CacheEviction.Enabled() && !p.Offline {
+		evictCache(p.CacheEviction, codedir)
 	}
 
 	outputFile := os.Getenv("DRONE_OUTPUT")
 	outputVars := []string{}
 
 	if codedir != "" {
-		var err error
 		outputVars, err = utils.ParseActionOutputs(codedir)
 		if err != nil {
+			if p.Strict {
+				return errors.Wrapf(err, "failed to parse outputs of action %s", p.Action.Uses)
+			}
 			logrus.Warnf("Could not parse action.yml outputs from %s: %v", codedir, err)
 		}
 	}
 
+	var using string
+	if codedir != "" {
+		spec, err := utils.ParseActionSpec(filepath.Join(codedir, utils.ParseActionPath(p.Action.Uses)))
+		if err != nil {
+			if pol != nil {
+				return errors.Wrap(err, "failed to parse action.yml for policy evaluation")
+			}
+			logrus.Warnf("Could not parse action.yml of %s: %v", p.Action.Uses, err)
+		}
+		if spec != nil {
+			using = spec.Runs.Using
+			if isDockerAction(spec.Runs.Image) {
+				saved = append(saved, strings.TrimPrefix(spec.Runs.Image, "docker://"))
+			}
+		}
+	}
+
+	// docker actions are decided by CheckRef, they have no action.yml
+	if pol != nil && !isDockerAction(p.Action.Uses) {
+		if codedir == "" {
+			return errors.New("action policy cannot be evaluated without a cloned action")
+		}
+		if err := pol.Check(policy.Input{Repo: repoURL, Ref: ref, Using: using}); err != nil {
+			return err
+		}
+	}
+
+	runsOn := p.Action.RunsOn
+	if runsOn == "" {
+		runsOn = utils.DefaultRunsOn
+	}
+	image := p.Action.Platforms[runsOn]
+	if image == "" {
+		image = p.Action.Image
+	}
+	if image == "" {
+		image = utils.RunnerImage(using, p.Action.Runtimes)
+	}
+	saved = append(saved, p.Images.Pull...)
+	saved = append(saved, image)
+	if isDockerAction(p.Action.Uses) {
+		saved = append(saved, strings.TrimPrefix(p.Action.Uses, "docker://"))
+	}
+	platforms, err := utils.Platforms(p.Action.Platforms, runsOn, image)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Running the action on %s using image %s", runsOn, image)
+
 	if len(outputVars) == 0 {
 		logrus.Infof("No outputs were found in action.yml for repo: %s", repoURL)
 	}
 
-	if err := utils.CreateWorkflowFile(workflowFile, p.Action.Uses,
+	if err := utils.CreateWorkflowFile(workflowFile, p.Action.Uses, runsOn,
 		p.Action.With, p.Action.Env, outputFile, outputVars); err != nil {
 		return err
 	}
@@ -90,22 +248,42 @@ func (p Plugin) Exec() error {
 		return err
 	}
 
+	// containers started by act are bound paths the engine resolves,
+	// which differ from those of the step container for the daemon of
+	// the host
 	outputFilePath := GetDirPath(outputFile)
-	containerOptions := fmt.Sprintf("-v=%s:%s", outputFilePath, outputFilePath)
+	var containerOptions string
+	if hostOutputPath, err := engine.HostPath(outputFilePath); err != nil {
+		if outputFile != "" {
+			logrus.Warnf("Output variables are not available to the action: %v", err)
+		}
+	} else {
+		containerOptions = fmt.Sprintf("-v=%s:%s", hostOutputPath, outputFilePath)
+	}
+	workdir, err := bindWorkdir(engine)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind the workspace")
+	}
 
 	cmdArgs := []string{
 		"-W",
 		workflowFile,
-		"-P",
-		fmt.Sprintf("ubuntu-latest=%s", p.Action.Image),
 		"--secret-file",
 		secretFile,
 		"--env-file",
 		envFile,
 		"-b",
 		"--detect-event",
-		"--container-options",
-		fmt.Sprintf("\"%s\"", containerOptions),
+	}
+	if workdir != "" {
+		cmdArgs = append(cmdArgs, "-C", workdir)
+	}
+	if containerOptions != "" {
+		cmdArgs = append(cmdArgs, "--container-options", fmt.Sprintf("\"%s\"", containerOptions))
+	}
+
+	for _, platform := range platforms {
+		cmdArgs = append(cmdArgs, "-P", platform)
 	}
 
 	// optional arguments
@@ -122,22 +300,141 @@ func (p Plugin) Exec() error {
 		cmdArgs = append(cmdArgs, "--eventpath", eventPayloadFile)
 	}
 
+	if p.Offline {
+		// serve the action from the cache instead of letting act
+		// fetch it, and use images already present in the daemon.
+		cmdArgs = append(cmdArgs, "--action-offline-mode", "--pull=false")
+		if codedir != "" {
+			cmdArgs = append(cmdArgs, "--local-repository", fmt.Sprintf("%s@%s=%s", repoURL, ref, codedir))
+		}
+	}
+
 	if p.Action.Verbose {
 		cmdArgs = append(cmdArgs, "-v")
 	}
 
+	if err := <-pulled; err != nil {
+		logrus.Warnf("Failed to pull images, act pulls them when needed: %v", err)
+	}
+
 	cmd := exec.Command("act", cmdArgs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	trace(cmd)
 
-	err := cmd.Run()
+	err = cmd.Run()
+	if p.Images.Save {
+		if saveErr := daemon.SaveImages(ctx, p.Images.Archive, saved); saveErr != nil {
+			logrus.Warnf("Failed to save images to %s: %v", p.Images.Archive, saveErr)
+		}
+	}
 	if err != nil {
+		dumpDaemonLog(engine, p.Daemon.LogFile)
 		return err
 	}
 	return nil
 }
 
+// dumpDaemonLog writes the last lines of the output of the container
+// engine, which often explain why act failed.
+func dumpDaemonLog(engine daemon.Engine, logFile string) {
+	lines := engine.Log()
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stdout, "Last lines of the container engine output:")
+	for _, line := range lines {
+		fmt.Fprintln(os.Stdout, line)
+	}
+	if logFile != "" {
+		logrus.Infof("The complete container engine output is in %s", logFile)
+	}
+}
+
+// bindWorkdir returns the working directory act binds into the job
+// container if it differs from the current directory. The engine binds
+// the path of the workspace on the host, which is linked to the
+// workspace in the step container so that act reads the same files.
+func bindWorkdir(engine daemon.Engine) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	hostWd, err := engine.HostPath(wd)
+	if err != nil || hostWd == wd {
+		return "", err
+	}
+
+	if _, err := os.Lstat(hostWd); err == nil {
+		if !sameFile(wd, hostWd) {
+			return "", errors.Errorf("%s of the host is a different directory in the step container", hostWd)
+		}
+		return hostWd, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(hostWd), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Symlink(wd, hostWd); err != nil {
+		return "", err
+	}
+	logrus.Infof("Binding the workspace %s from %s of the host", wd, hostWd)
+	return hostWd, nil
+}
+
+// sameFile returns true if both paths refer to the same file.
+func sameFile(a, b string) bool {
+	x, errA := os.Stat(a)
+	y, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(x, y)
+}
+
+// recoverCache removes what steps which did not finish changing the
+// action cache left behind.
+func recoverCache() {
+	removed, err := cache.Recover(cache.AbandonedAge)
+	if err != nil {
+		logrus.Warnf("Failed to recover action cache: %v", err)
+	}
+	if len(removed) != 0 {
+		logrus.Infof("Removed %d abandoned entries from the action cache", len(removed))
+	}
+}
+
+// evictCache removes entries from the action cache according to the
+// eviction policy, always keeping the action of the current step.
+func evictCache(opts cache.EvictOptions, codedir string) {
+	if codedir != "" {
+		opts.Keep = append(opts.Keep, filepath.Dir(codedir))
+	}
+	removed, err := cache.Evict(opts)
+	if err != nil {
+		logrus.Warnf("Failed to evict action cache entries: %v", err)
+	}
+	if len(removed) != 0 {
+		logrus.Infof("Evicted %d entries from the action cache", len(removed))
+	}
+}
+
+// cloneError returns the clone error with a hint on how to resolve it.
+func cloneError(repo, ref string, err error) error {
+	hint := "check the 'uses' reference"
+	switch {
+	case errors.Is(err, cloner.ErrAuth):
+		hint = "check that GITHUB_TOKEN is set and has access to the repository"
+	case errors.Is(err, cloner.ErrRepoNotFound):
+		hint = "check the owner and repository in 'uses', private repositories require GITHUB_TOKEN"
+	case errors.Is(err, cloner.ErrRefNotFound):
+		hint = "check that the branch, tag or commit exists in the repository"
+	case errors.Is(err, cloner.ErrNetwork):
+		hint = "check that the git host is reachable from the runner"
+	}
+	return errors.Wrapf(err, "failed to clone action %s@%s, %s", repo, ref, hint)
+}
+
+func isDockerAction(uses string) bool {
+	return strings.HasPrefix(uses, "docker://")
+}
+
 // trace writes each command to stdout with the command wrapped in an xml
 // tag so that it can be extracted and displayed in the logs.
 func trace(cmd *exec.Cmd) {
@@ -0,0 +1,239 @@
+// Package policy decides whether a github action is allowed to run
+// based on a user provided policy file.
+package policy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// regular expression to test whether or not a ref is a full
+// sha1 or sha256 commit hash.
+var shaRe = regexp.MustCompile("^([a-f0-9]{40}|[a-f0-9]{64})$")
+
+// dockerPrefix is the prefix of `uses` of actions running an image and
+// of the rules matching them.
+const dockerPrefix = "docker://"
+
+type (
+	// Policy is the parsed representation of a policy file.
+	Policy struct {
+		Default       string   `yaml:"default"`        // Decision if no rule matches (allow or deny)
+		RequireSha    bool     `yaml:"require_sha"`    // Require full commit sha pins for non-trusted owners
+		TrustedOwners []string `yaml:"trusted_owners"` // Owner globs exempt from require_sha
+		Rules         []Rule   `yaml:"rules"`          // Rules evaluated in order
+	}
+
+	// Rule matches actions by `owner/repo` glob, `host/owner/repo` glob
+	// for hosts other than github.com, or `docker://` actions by
+	// `docker://image` glob.
+	Rule struct {
+		Uses      string   `yaml:"uses"`       // Glob matched against owner/repo, host/owner/repo or docker://image
+		Action    string   `yaml:"action"`     // allow or deny, empty only checks block_refs
+		Using     []string `yaml:"using"`      // Optional runs.using values the rule is limited to
+		BlockRefs []string `yaml:"block_refs"` // Refs that are always denied
+		Message   string   `yaml:"message"`    // Message shown when the rule denies an action
+	}
+
+	// Input describes the action being evaluated.
+	Input struct {
+		Repo  string // Repository url as returned by utils.ParseLookup
+		Ref   string // Reference as returned by utils.ParseLookup
+		Using string // runs.using from action.yml
+		Image string // Image of docker:// actions, which have no repository
+	}
+
+	// DeniedError is returned when the policy denies an action.
+	DeniedError struct {
+		Action string
+		Reason string
+	}
+)
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("action %s is not allowed by policy: %s", e.Action, e.Reason)
+}
+
+// Load reads and validates the policy file.
+func Load(file string) (*Policy, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy file")
+	}
+	return Parse(raw)
+}
+
+// Parse parses and validates policy file contents.
+func Parse(raw []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(raw, p); err != nil {
+		return nil, errors.Wrap(err, "failed to parse policy file")
+	}
+	if p.Default == "" {
+		p.Default = Allow
+	}
+	if p.Default != Allow && p.Default != Deny {
+		return nil, fmt.Errorf("invalid policy default %q, must be %s or %s", p.Default, Allow, Deny)
+	}
+	for i, r := range p.Rules {
+		if r.Uses == "" {
+			return nil, fmt.Errorf("policy rule %d: uses must be set", i)
+		}
+		if _, err := path.Match(r.Uses, ""); err != nil {
+			return nil, fmt.Errorf("policy rule %d: invalid uses glob %q: %v", i, r.Uses, err)
+		}
+		if r.Action != "" && r.Action != Allow && r.Action != Deny {
+			return nil, fmt.Errorf("policy rule %d: invalid action %q, must be %s or %s", i, r.Action, Allow, Deny)
+		}
+		if r.Action == "" && len(r.BlockRefs) == 0 {
+			return nil, fmt.Errorf("policy rule %d: one of action or block_refs must be set", i)
+		}
+	}
+	return p, nil
+}
+
+// CheckRef evaluates the policy before the action is cloned. Rules
+// restricted by runs.using cannot be decided yet and defer the
+// decision to Check.
+func (p *Policy) CheckRef(in Input) error {
+	return p.evaluate(in, false)
+}
+
+// Check evaluates the policy once action.yml of the action is known.
+func (p *Policy) Check(in Input) error {
+	return p.evaluate(in, true)
+}
+
+func (p *Policy) evaluate(in Input, resolved bool) error {
+	var name, action, owner string
+	pinned := shaRe.MatchString(in.Ref)
+	if in.Image != "" {
+		// docker:// actions run the image as is, so they are always
+		// container actions and can be decided before clone
+		name = dockerPrefix + in.Image
+		action = name
+		in.Using, in.Ref, resolved = "docker", "", true
+		pinned = strings.Contains(in.Image, "@sha256:")
+	} else {
+		var err error
+		if name, err = repoName(in.Repo); err != nil {
+			return &DeniedError{Action: in.Repo, Reason: err.Error()}
+		}
+		action = name
+		if in.Ref != "" {
+			action = name + "@" + in.Ref
+		}
+		owner = path.Dir(name)
+	}
+
+	decision, pending := "", false
+	for _, r := range p.Rules {
+		// repository rules never match images and vice versa
+		if strings.HasPrefix(r.Uses, dockerPrefix) != (in.Image != "") || !match(r.Uses, name) {
+			continue
+		}
+		if len(r.Using) != 0 {
+			if !resolved {
+				// runs.using is not known before clone, so no
+				// later rule can be trusted to decide either.
+				pending = true
+				break
+			}
+			if !containsFold(r.Using, in.Using) {
+				continue
+			}
+		}
+		if containsFold(r.BlockRefs, in.Ref) {
+			return &DeniedError{Action: action, Reason: reason(r, fmt.Sprintf("ref %s is blocked by rule %q", in.Ref, r.Uses))}
+		}
+		if r.Action == Deny {
+			return &DeniedError{Action: action, Reason: reason(r, fmt.Sprintf("denied by rule %q", r.Uses))}
+		}
+		if r.Action == Allow {
+			decision = Allow
+			break
+		}
+	}
+
+	if decision == "" && !pending && p.Default == Deny {
+		return &DeniedError{Action: action, Reason: "no rule allows it and the policy default is deny"}
+	}
+
+	switch {
+	case !p.RequireSha || pinned:
+	case in.Image != "":
+		return &DeniedError{Action: action, Reason: "pin the image to a sha256 digest"}
+	case !p.trusted(owner):
+		return &DeniedError{Action: action, Reason: fmt.Sprintf("owner %s is not trusted, pin the action to a full commit sha", owner)}
+	}
+	return nil
+}
+
+func (p *Policy) trusted(owner string) bool {
+	for _, pattern := range p.TrustedOwners {
+		if match(pattern, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// githubHost is the host whose repositories are named `owner/repo` by
+// rules. Repositories on other hosts are named `host/owner/repo`, so
+// rules for github.com owners never match them.
+const githubHost = "github.com"
+
+// repoName returns `owner/repo` for a github.com repository url and
+// `host/owner/repo` for repositories on other hosts.
+func repoName(repo string) (string, error) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid repository url %s", repo)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid repository url %s", repo)
+	}
+	name := strings.TrimSuffix(parts[0]+"/"+parts[1], ".git")
+	if !strings.EqualFold(u.Host, githubHost) {
+		name = strings.ToLower(u.Host) + "/" + name
+	}
+	return name, nil
+}
+
+// match reports whether name matches the glob, ignoring case
+// since github owner and repository names are case insensitive. As in
+// path.Match, `*` does not match `/`, so `*/*` matches any github.com
+// repository and `*` none.
+func match(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+func containsFold(slice []string, val string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func reason(r Rule, fallback string) string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return fallback
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+default: deny
+require_sha: true
+trusted_owners:
+  - actions
+  - my-org*
+rules:
+  - uses: actions/checkout
+    block_refs: [v1]
+    message: actions/checkout@v1 is vulnerable, upgrade to v4
+  - uses: evil/*
+    action: deny
+  - uses: "*/*"
+    using: [docker]
+    action: deny
+    message: container actions are not allowed
+  - uses: "*/*"
+    action: allow
+`
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.NoError(t, err)
+
+	sha := "8f4b7f84864484a7bf31766abe9204da3cbe65b3"
+	for name, tt := range map[string]struct {
+		in      Input
+		preErr  string
+		postErr string
+	}{
+		"trusted": {
+			in: Input{Repo: "https://github.com/actions/checkout", Ref: "v4", Using: "node20"},
+		},
+		"trusted-glob": {
+			in: Input{Repo: "https://github.com/My-Org-Tools/setup", Ref: "main", Using: "composite"},
+		},
+		"blocked-ref": {
+			in:      Input{Repo: "https://github.com/actions/checkout", Ref: "v1"},
+			preErr:  "actions/checkout@v1 is vulnerable, upgrade to v4",
+			postErr: "actions/checkout@v1 is vulnerable, upgrade to v4",
+		},
+		"denied-owner": {
+			in:      Input{Repo: "https://github.com/evil/miner", Ref: sha},
+			preErr:  `denied by rule "evil/*"`,
+			postErr: `denied by rule "evil/*"`,
+		},
+		"unpinned": {
+			in:      Input{Repo: "https://github.com/someone/action", Ref: "v1", Using: "node20"},
+			preErr:  "pin the action to a full commit sha",
+			postErr: "pin the action to a full commit sha",
+		},
+		"pinned": {
+			in: Input{Repo: "https://github.com/someone/action", Ref: sha, Using: "node20"},
+		},
+		"docker": {
+			in:      Input{Repo: "https://github.com/someone/action", Ref: sha, Using: "docker"},
+			postErr: "container actions are not allowed",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := p.CheckRef(tt.in)
+			if tt.preErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.preErr)
+			}
+
+			err = p.Check(tt.in)
+			if tt.postErr == "" {
+				assert.NoError(t, err)
+			} else {
+				var denied *DeniedError
+				assert.ErrorAs(t, err, &denied)
+				assert.ErrorContains(t, err, tt.postErr)
+			}
+		})
+	}
+}
+
+func TestPolicyImages(t *testing.T) {
+	p, err := Parse([]byte(`
+default: deny
+rules:
+  - uses: "*/*"
+    action: allow
+  - uses: docker://ghcr.io/my-org/*
+    action: allow
+  - uses: docker://alpine:*
+    action: allow
+`))
+	require.NoError(t, err)
+
+	for _, image := range []string{"ghcr.io/my-org/tool:1.2", "alpine:3.20"} {
+		assert.NoError(t, p.CheckRef(Input{Image: image}), image)
+		assert.NoError(t, p.Check(Input{Image: image}), image)
+	}
+	// repository rules do not match images
+	assert.ErrorContains(t, p.CheckRef(Input{Image: "evil/miner:latest"}), "no rule allows it")
+	assert.ErrorContains(t, p.CheckRef(Input{Image: "ghcr.io/other/tool:1"}), "no rule allows it")
+
+	// images are container actions, decided before clone
+	p, err = Parse([]byte(testPolicy + "  - uses: docker://*\n    action: allow\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, p.CheckRef(Input{Image: "alpine:3.20"}), "pin the image to a sha256 digest")
+	digest := "alpine@sha256:" + strings.Repeat("a", 64)
+	assert.NoError(t, p.CheckRef(Input{Image: digest}))
+
+	p, err = Parse([]byte("rules:\n  - uses: docker://*\n    using: [docker]\n    action: deny\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, p.CheckRef(Input{Image: digest}), `denied by rule "docker://*"`)
+}
+
+func TestPolicyDefaultDeny(t *testing.T) {
+	p, err := Parse([]byte("default: deny\nrules:\n  - uses: actions/*\n    action: allow\n"))
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Check(Input{Repo: "https://github.com/actions/checkout", Ref: "v4"}))
+	assert.ErrorContains(t, p.CheckRef(Input{Repo: "https://github.com/other/checkout", Ref: "v4"}),
+		"no rule allows it and the policy default is deny")
+}
+
+func TestPolicyHosts(t *testing.T) {
+	p, err := Parse([]byte(`
+default: deny
+require_sha: true
+trusted_owners: [actions, ghe.example.com/platform]
+rules:
+  - uses: actions/*
+    action: allow
+  - uses: ghe.example.com/platform/*
+    action: allow
+`))
+	require.NoError(t, err)
+
+	// github.com rules do not match repositories on other hosts
+	for _, repo := range []string{"https://evil.example.com/actions/checkout", "https://github.com.evil.example.com/actions/checkout"} {
+		in := Input{Repo: repo, Ref: "v4", Using: "node20"}
+		assert.ErrorContains(t, p.CheckRef(in), "no rule allows it", repo)
+		assert.ErrorContains(t, p.Check(in), "no rule allows it", repo)
+	}
+	assert.NoError(t, p.Check(Input{Repo: "https://GitHub.com/actions/checkout", Ref: "v4"}))
+
+	// other hosts are allowed and trusted by rules naming them
+	assert.NoError(t, p.Check(Input{Repo: "https://ghe.example.com/platform/setup", Ref: "v1"}))
+	p.TrustedOwners = []string{"platform"}
+	assert.ErrorContains(t, p.Check(Input{Repo: "https://ghe.example.com/platform/setup", Ref: "v1"}),
+		"owner ghe.example.com/platform is not trusted")
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, raw := range map[string]string{
+		"default":    "default: maybe",
+		"action":     "rules:\n  - uses: a/b\n    action: block",
+		"empty-rule": "rules:\n  - uses: a/b",
+		"glob":       "rules:\n  - uses: \"a/[\"\n    action: deny",
+		"unknown":    "allow_all: true",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(raw))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package prefetch warms the action cache by cloning actions,
+// including the actions referenced by composite actions, in parallel.
+package prefetch
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drone-plugins/drone-github-actions/utils"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Cloner clones a repository into the action cache and
+	// returns the directory of the cached repository.
+	Cloner interface {
+		Clone(ctx context.Context, repo, ref, sha string) (string, error)
+	}
+
+	// Result is the outcome of prefetching a single action.
+	Result struct {
+		Uses   string   // Action reference
+		Parent string   // Composite action referencing the action, empty for top-level actions
+		Dir    string   // Directory of the cached repository
+		Deps   []string // Actions referenced by the action if it is a composite action
+		Err    error
+	}
+)
+
+// Run clones the remote actions and, recursively, all remote actions
+// referenced by composite actions with up to concurrency clones running in parallel.
+// Results are returned in the order the actions were discovered.
+func Run(ctx context.Context, c Cloner, uses []string, concurrency int) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		results []Result
+		queue   []Result
+		seen    = map[string]bool{}
+	)
+	enqueue := func(uses, parent string) {
+		if !seen[uses] && cloned(uses) {
+			seen[uses] = true
+			queue = append(queue, Result{Uses: uses, Parent: parent})
+		}
+	}
+	for _, u := range uses {
+		enqueue(u, "")
+	}
+
+	for len(queue) != 0 {
+		level := queue
+		queue = nil
+
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i := range level {
+			wg.Add(1)
+			go func(r *Result) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				r.Dir, r.Deps, r.Err = fetch(ctx, c, r.Uses)
+			}(&level[i])
+		}
+		wg.Wait()
+
+		for _, r := range level {
+			results = append(results, r)
+			for _, dep := range r.Deps {
+				enqueue(dep, r.Uses)
+			}
+		}
+	}
+	return results
+}
+
+// fetch clones a single action and returns the remote actions
+// referenced by its steps if it is a composite action.
+func fetch(ctx context.Context, c Cloner, uses string) (string, []string, error) {
+	repo, ref, err := utils.ParseReference(uses)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := c.Clone(ctx, repo, ref, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	spec, err := utils.ParseActionSpec(filepath.Join(dir, utils.ParseActionPath(uses)))
+	if err != nil {
+		return dir, nil, errors.Wrapf(err, "failed to parse action %s", uses)
+	}
+	if spec == nil || spec.Runs.Using != "composite" {
+		return dir, nil, nil
+	}
+
+	var deps []string
+	for _, step := range spec.Runs.Steps {
+		if step.Uses != "" && cloned(step.Uses) {
+			deps = append(deps, step.Uses)
+		}
+	}
+	return dir, deps, nil
+}
+
+// cloned returns false for local and docker actions, which do not need
+// to be cloned.
+func cloned(uses string) bool {
+	return !strings.HasPrefix(uses, "./") && !strings.HasPrefix(uses, "docker://")
+}
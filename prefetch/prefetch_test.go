@@ -0,0 +1,118 @@
+package prefetch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drone-plugins/drone-github-actions/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloner serves action.yml files from memory keyed by repo@ref/path.
+type fakeCloner struct {
+	root    string
+	actions map[string]string
+
+	mu     sync.Mutex
+	clones []string
+}
+
+func (f *fakeCloner) Clone(_ context.Context, repo, ref, _ string) (string, error) {
+	f.mu.Lock()
+	f.clones = append(f.clones, repo+"@"+ref)
+	f.mu.Unlock()
+
+	name := strings.TrimPrefix(repo, "https://github.com/") + "@" + ref
+	dir := filepath.Join(f.root, strings.NewReplacer("/", "_", "@", "_").Replace(name))
+	found := false
+	for uses, spec := range f.actions {
+		prefix, path, _ := strings.Cut(uses, ":")
+		if prefix != name {
+			continue
+		}
+		found = true
+		if err := os.MkdirAll(filepath.Join(dir, path), 0700); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, path, "action.yml"), []byte(spec), 0644); err != nil {
+			return "", err
+		}
+	}
+	if !found {
+		return "", errors.New("not found")
+	}
+	return dir, nil
+}
+
+func TestRun(t *testing.T) {
+	c := &fakeCloner{
+		root: t.TempDir(),
+		actions: map[string]string{
+			"org/composite@v1:": `
+runs:
+  using: composite
+  steps:
+    - uses: actions/checkout@v4
+    - uses: org/mono/sub@v2
+    - uses: ./local
+    - uses: docker://alpine
+    - run: echo hello
+`,
+			"actions/checkout@v4:": "runs:\n  using: node20\n",
+			"org/mono@v2:sub":      "runs:\n  using: composite\n  steps:\n    - uses: actions/checkout@v4\n",
+		},
+	}
+
+	results := Run(context.Background(), c, []string{"org/composite@v1", "actions/checkout@v4", "org/missing@v1"}, 2)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, "org/composite@v1", results[0].Uses)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, []string{"actions/checkout@v4", "org/mono/sub@v2"}, results[0].Deps)
+
+	assert.Equal(t, "actions/checkout@v4", results[1].Uses)
+	assert.Empty(t, results[1].Parent)
+	assert.NoError(t, results[1].Err)
+
+	assert.Equal(t, "org/missing@v1", results[2].Uses)
+	assert.Error(t, results[2].Err)
+
+	assert.Equal(t, "org/mono/sub@v2", results[3].Uses)
+	assert.Equal(t, "org/composite@v1", results[3].Parent)
+	assert.NoError(t, results[3].Err)
+
+	// actions referenced more than once are only cloned once
+	assert.Len(t, c.clones, 4)
+}
+
+func TestRunPipeline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".drone.yml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+kind: pipeline
+name: default
+steps:
+- name: checkout
+  image: plugins/github-actions
+  settings:
+    uses: actions/checkout@v4
+- name: lint
+  image: plugins/github-actions
+  settings:
+    uses: docker://ghcr.io/org/linter:1.0
+`), 0644))
+	uses, err := utils.ParsePipelineUses(file, []string{"plugins/github-actions"})
+	require.NoError(t, err)
+
+	// docker actions run an image and are not prefetched
+	c := &fakeCloner{root: t.TempDir(), actions: map[string]string{"actions/checkout@v4:": "runs:\n  using: node20\n"}}
+	results := Run(context.Background(), c, uses, 2)
+	require.Len(t, results, 1)
+	assert.Equal(t, "actions/checkout@v4", results[0].Uses)
+	assert.NoError(t, results[0].Err)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -16,10 +17,22 @@ import (
 
 type GHActionSpec struct {
 	Outputs map[string]interface{} `yaml:"outputs,omitempty"`
+	Runs    GHActionRuns           `yaml:"runs,omitempty"`
 }
 
-// ParseActionOutputs locates `action.yml` or `action.yaml` in `root` and returns all top-level outputs.
-func ParseActionOutputs(root string) ([]string, error) {
+type GHActionRuns struct {
+	Using string         `yaml:"using,omitempty"`
+	Image string         `yaml:"image,omitempty"`
+	Steps []GHActionStep `yaml:"steps,omitempty"` // Steps of composite actions
+}
+
+type GHActionStep struct {
+	Uses string `yaml:"uses,omitempty"`
+}
+
+// ParseActionSpec locates `action.yml` or `action.yaml` in `root` and returns the parsed spec.
+// A nil spec is returned without error if neither file exists.
+func ParseActionSpec(root string) (*GHActionSpec, error) {
 	ymlPath := filepath.Join(root, "action.yml")
 	yamlPath := filepath.Join(root, "action.yaml")
 
@@ -30,8 +43,7 @@ func ParseActionOutputs(root string) ([]string, error) {
 	case fileExists(yamlPath):
 		actionFile = yamlPath
 	default:
-		logrus.Warnf("action.yml or action.yaml not found in %s. Skipping output variable processing.", root)
-		return []string{}, nil
+		return nil, nil
 	}
 
 	raw, err := os.ReadFile(actionFile)
@@ -43,6 +55,19 @@ func ParseActionOutputs(root string) ([]string, error) {
 	if err := yaml.Unmarshal(raw, &spec); err != nil {
 		return nil, fmt.Errorf("failed to parse action.yml: %w", err)
 	}
+	return &spec, nil
+}
+
+// ParseActionOutputs locates `action.yml` or `action.yaml` in `root` and returns all top-level outputs.
+func ParseActionOutputs(root string) ([]string, error) {
+	spec, err := ParseActionSpec(root)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		logrus.Warnf("action.yml or action.yaml not found in %s. Skipping output variable processing.", root)
+		return []string{}, nil
+	}
 
 	keys := make([]string, 0, len(spec.Outputs))
 	for k := range spec.Outputs {
@@ -60,14 +85,38 @@ func fileExists(path string) bool {
 	return !info.IsDir()
 }
 
+// ErrInvalidReference is returned when a `uses` reference cannot be parsed.
+var ErrInvalidReference = errors.New("invalid action reference")
+
+// ParseReference parses the step string and returns the associated
+// repository and ref. Unlike ParseLookup, it returns an error wrapping
+// ErrInvalidReference if the string is not a valid `{owner}/{repo}[/path]@{ref}`
+// or `https://{host}/{owner}/{repo}@{ref}` reference.
+func ParseReference(s string) (repo string, ref string, err error) {
+	if org, repo, _, ref, err := parseActionName(s); err == nil {
+		return fmt.Sprintf("https://github.com/%s/%s", org, repo), ref, nil
+	}
+
+	if u, err := url.Parse(s); err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" {
+		parts := strings.SplitN(s, "@", 2)
+		path := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) == 2 && parts[1] != "" && len(path) >= 2 && path[0] != "" {
+			return parts[0], parts[1], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w %q, expected {owner}/{repo}[/path]@{ref}", ErrInvalidReference, s)
+}
+
 // ParseLookup parses the step string and returns the
-// associated repository and ref.
+// associated repository and ref. Strings which are not valid
+// references are resolved against github.com on a best effort
+// basis with ok set to false.
 func ParseLookup(s string) (repo string, ref string, ok bool) {
-	org, repo, _, ref, err := parseActionName(s)
+	repo, ref, err := ParseReference(s)
 	if err == nil {
-		url := fmt.Sprintf("https://github.com/%s/%s", org, repo)
-		slog.Debug(fmt.Sprintf("parsed repo: %s, ref: %s", url, ref))
-		return url, ref, true
+		slog.Debug(fmt.Sprintf("parsed repo: %s, ref: %s", repo, ref))
+		return repo, ref, true
 	}
 
 	slog.Warn(fmt.Sprintf("failed to parse action name: %s with err: %v", s, err))
@@ -77,9 +126,16 @@ func ParseLookup(s string) (repo string, ref string, ok bool) {
 
 	slog.Debug("parsed repo", s)
 	if parts := strings.SplitN(s, "@", 2); len(parts) == 2 {
-		return parts[0], parts[1], true
+		return parts[0], parts[1], false
 	}
-	return s, "", true
+	return s, "", false
+}
+
+// ParseActionPath returns the path of the action inside its
+// repository, e.g. `init` for `github/codeql-action/init@v3`.
+func ParseActionPath(s string) string {
+	_, _, path, _, _ := parseActionName(s)
+	return path
 }
 
 func parseActionName(action string) (org, repo, path, ref string, err error) {
@@ -43,3 +43,47 @@ outputs:
 	assert.NoError(t, err)
 	assert.Empty(t, outputs)
 }
+
+func TestParseReference(t *testing.T) {
+	for uses, want := range map[string][2]string{
+		"actions/checkout@v4":                  {"https://github.com/actions/checkout", "v4"},
+		"github/codeql-action/init@v3":         {"https://github.com/github/codeql-action", "v3"},
+		"https://github.com/actions/cache@v4":  {"https://github.com/actions/cache", "v4"},
+		"https://git.example.com/org/repo@dev": {"https://git.example.com/org/repo", "dev"},
+	} {
+		repo, ref, err := ParseReference(uses)
+		assert.NoError(t, err, uses)
+		assert.Equal(t, want[0], repo, uses)
+		assert.Equal(t, want[1], ref, uses)
+	}
+
+	for _, uses := range []string{
+		"",
+		"actions/checkout",
+		"checkout@v4",
+		"docker://alpine:3.20",
+		"https://github.com/actions@v4",
+	} {
+		_, _, err := ParseReference(uses)
+		assert.ErrorIs(t, err, ErrInvalidReference, uses)
+	}
+}
+
+func TestParseLookup(t *testing.T) {
+	repo, ref, ok := ParseLookup("actions/checkout@v4")
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.com/actions/checkout", repo)
+	assert.Equal(t, "v4", ref)
+
+	// invalid references are still resolved against github.com, but
+	// reported as such with ok set to false
+	repo, ref, ok = ParseLookup("checkout@v4")
+	assert.False(t, ok)
+	assert.Equal(t, "https://github.com/checkout", repo)
+	assert.Equal(t, "v4", ref)
+
+	repo, ref, ok = ParseLookup("actions/checkout")
+	assert.False(t, ok)
+	assert.Equal(t, "https://github.com/actions/checkout", repo)
+	assert.Empty(t, ref)
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type pipeline struct {
+	Steps []pipelineStep `yaml:"steps"`
+}
+
+type pipelineStep struct {
+	Image    string `yaml:"image"`
+	Settings struct {
+		Uses string `yaml:"uses"`
+	} `yaml:"settings"`
+}
+
+// ParsePipelineUses returns the `uses` setting of every step in the drone
+// pipeline file which runs one of the given plugin images.
+func ParsePipelineUses(file string, images []string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pipeline file")
+	}
+	defer f.Close()
+
+	var uses []string
+	dec := yaml.NewDecoder(f)
+	for {
+		var p pipeline
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse pipeline file")
+		}
+		for _, s := range p.Steps {
+			if s.Settings.Uses != "" && Exists(images, imageName(s.Image)) {
+				uses = append(uses, s.Settings.Uses)
+			}
+		}
+	}
+	return uses, nil
+}
+
+// imageName returns the image without tag or digest.
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePipelineUses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".drone.yml")
+	pipeline := `
+kind: pipeline
+name: default
+steps:
+- name: checkout
+  image: plugins/github-actions
+  settings:
+    uses: actions/checkout@v4
+- name: lint
+  image: plugins/github-actions
+  settings:
+    uses: docker://ghcr.io/org/linter:1.0
+- name: build
+  image: golang:1.22
+  commands:
+  - go build
+---
+kind: pipeline
+name: release
+steps:
+- name: setup
+  image: registry.example.com:5000/plugins/github-actions:1.2@sha256:abc
+  settings:
+    uses: actions/setup-go@v5
+- name: other
+  image: plugins/docker
+  settings:
+    uses: ignored/action@v1
+`
+	err := os.WriteFile(file, []byte(pipeline), 0644)
+	assert.NoError(t, err)
+
+	uses, err := ParsePipelineUses(file, []string{"plugins/github-actions", "registry.example.com:5000/plugins/github-actions"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout@v4", "docker://ghcr.io/org/linter:1.0", "actions/setup-go@v5"}, uses)
+}
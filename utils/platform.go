@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultRunsOn is the runs-on label of the job running the action.
+const DefaultRunsOn = "ubuntu-latest"
+
+// defaultRunnerImage runs actions whose runtime has no image of its own,
+// such as docker and composite actions, and actions without action.yml.
+const defaultRunnerImage = "node:20-bookworm-slim"
+
+// runtimeImages are the runner images of the node runtimes of actions,
+// each providing the node version the runtime requires.
+var runtimeImages = map[string]string{
+	"node12": "node:16-bullseye-slim",
+	"node16": "node:16-bullseye-slim",
+	"node20": "node:20-bookworm-slim",
+	"node24": "node:24-bookworm-slim",
+}
+
+// RunnerImage returns the runner image of an action using the runtime
+// declared in `runs.using` of action.yml. Images set for runtimes take
+// precedence over the default images.
+func RunnerImage(using string, images map[string]string) string {
+	using = strings.ToLower(strings.TrimSpace(using))
+	if image := images[using]; image != "" {
+		return image
+	}
+	if image := runtimeImages[using]; image != "" {
+		return image
+	}
+	return defaultRunnerImage
+}
+
+// Platforms returns the platforms passed to act as `label=image`, sorted
+// by label. The runs-on label is mapped to image unless the platform map
+// sets an image for it.
+func Platforms(platforms map[string]string, runsOn, image string) ([]string, error) {
+	m := map[string]string{runsOn: image}
+	for label, img := range platforms {
+		label, img = strings.TrimSpace(label), strings.TrimSpace(img)
+		if label == "" || strings.ContainsAny(label, "= \t\n") {
+			return nil, fmt.Errorf("invalid platform label %q", label)
+		}
+		if img == "" {
+			return nil, fmt.Errorf("platform %s has no image", label)
+		}
+		m[label] = img
+	}
+
+	labels := make([]string, 0, len(m))
+	for label := range m {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	out := make([]string, len(labels))
+	for i, label := range labels {
+		out[i] = label + "=" + m[label]
+	}
+	return out, nil
+}
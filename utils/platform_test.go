@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunnerImage(t *testing.T) {
+	tests := []struct {
+		using  string
+		images map[string]string
+		want   string
+	}{
+		{using: "node20", want: "node:20-bookworm-slim"},
+		{using: "node16", want: "node:16-bullseye-slim"},
+		{using: "Node12", want: "node:16-bullseye-slim"},
+		{using: "docker", want: defaultRunnerImage},
+		{using: "composite", want: defaultRunnerImage},
+		{using: "", want: defaultRunnerImage},
+		{using: "node20", images: map[string]string{"node20": "node:20"}, want: "node:20"},
+		{using: "composite", images: map[string]string{"composite": "catthehacker/ubuntu:act-22.04"}, want: "catthehacker/ubuntu:act-22.04"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, RunnerImage(test.using, test.images), test.using)
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	got, err := Platforms(nil, DefaultRunsOn, "node:20-bookworm-slim")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ubuntu-latest=node:20-bookworm-slim"}, got)
+
+	got, err = Platforms(map[string]string{
+		"ubuntu-22.04": "catthehacker/ubuntu:act-22.04",
+		"self-hosted":  "registry.example.com/runner:1",
+	}, "self-hosted", "node:20-bookworm-slim")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"self-hosted=registry.example.com/runner:1",
+		"ubuntu-22.04=catthehacker/ubuntu:act-22.04",
+	}, got)
+
+	_, err = Platforms(map[string]string{"ubuntu latest": "node:20"}, DefaultRunsOn, "node:20")
+	assert.Error(t, err)
+	_, err = Platforms(map[string]string{"ubuntu-22.04": ""}, DefaultRunsOn, "node:20")
+	assert.Error(t, err)
+}
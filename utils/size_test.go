@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"10b":    10,
+		"500MB":  500 * 1000 * 1000,
+		"2GiB":   2 << 30,
+		"1.5 G":  3 << 29,
+		"100kib": 100 << 10,
+	} {
+		got, err := ParseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+
+	for _, s := range []string{"ten", "-1GB", "1XB"} {
+		_, err := ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
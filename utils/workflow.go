@@ -38,14 +38,16 @@ const (
 	workflowEvent = "push"
 	workflowName  = "drone-github-action"
 	jobName       = "action"
-	runsOnImage   = "ubuntu-latest"
 )
 
-func CreateWorkflowFile(ymlFile string, action string,
+func CreateWorkflowFile(ymlFile string, action string, runsOn string,
 	with map[string]string, env map[string]string, outputFile string, outputVars []string) error {
+	if runsOn == "" {
+		runsOn = DefaultRunsOn
+	}
 	j := job{
 		Name:   jobName,
-		RunsOn: runsOnImage,
+		RunsOn: runsOn,
 		Steps: []step{
 			{
 				Id:   stepId,
@@ -19,7 +19,7 @@ func TestCreateWorkflowFile(t *testing.T) {
 	outputVars := []string{"out1", "out-2"}
 
 	// With output variables
-	err := CreateWorkflowFile(workflowFile, action, with, env, outputFile, outputVars)
+	err := CreateWorkflowFile(workflowFile, action, "", with, env, outputFile, outputVars)
 	assert.NoError(t, err)
 
 	content, err := os.ReadFile(workflowFile)
@@ -29,6 +29,7 @@ func TestCreateWorkflowFile(t *testing.T) {
 	assert.Contains(t, string(content), "uses: some-action@v1")
 	assert.Contains(t, string(content), "steps:")
 	assert.Contains(t, string(content), "id: stepIdentifier")
+	assert.Contains(t, string(content), "runs-on: ubuntu-latest")
 
 	// Check the `run` command
 	assert.Contains(t, string(content), "out1=${{ steps.stepIdentifier.outputs.out1 }}")
@@ -36,10 +37,11 @@ func TestCreateWorkflowFile(t *testing.T) {
 	assert.Contains(t, string(content), fmt.Sprintf("> %s", outputFile))
 
 	// Without output variables
-	err = CreateWorkflowFile(workflowFile, action, with, env, outputFile, []string{})
+	err = CreateWorkflowFile(workflowFile, action, "self-hosted", with, env, outputFile, []string{})
 	assert.NoError(t, err)
 	content, err = os.ReadFile(workflowFile)
 	assert.NoError(t, err)
+	assert.Contains(t, string(content), "runs-on: self-hosted")
 	assert.Contains(t, string(content), "name: output variables")
 	assert.Contains(t, string(content), "run: echo \"\" >")
 	assert.Contains(t, string(content), "if: \"false\"")